@@ -0,0 +1,771 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Aggregator computes per-station min/mean/max/count statistics. The zero
+// value is ready to use.
+type Aggregator struct {
+	// Workers is the number of goroutines parsing the input. Defaults to
+	// runtime.NumCPU().
+	Workers int
+
+	// SegmentSize is the size of the segments mmap-ed files are cut into.
+	// Each worker takes the next segment as soon as it is done with one, so
+	// a slow core or a slow region of the input only holds up its own
+	// segments. Defaults to 4 MiB. PerWorkerSegments cuts the files into
+	// Workers segments instead.
+	SegmentSize int
+
+	// MemoryBudget bounds the bytes of regular files mapped at any time.
+	// Unset, files are mapped whole and stay mapped until they have been
+	// aggregated. Set, every worker maps the segment it works on, plus a
+	// little to align it to lines, and unmaps it when it is done, with the
+	// segments capped at MemoryBudget / Workers / 2 (but no smaller than
+	// 64 KiB). Compressed files are streamed.
+	MemoryBudget int
+
+	// Backend is how regular files are read. Backends other than Mmap read
+	// every segment into a buffer of its worker, so they use a little more
+	// than MemoryBudget if set, or Workers * SegmentSize otherwise.
+	Backend IOBackend
+
+	// BufferSize is the size of the buffers read from inputs that cannot be
+	// mmap-ed (stdin, pipes, readers). Defaults to 4 MiB.
+	BufferSize int
+
+	// OnError picks between the fast, unchecked parser (the default) and
+	// the validating one, and what the latter does with malformed lines.
+	OnError ErrorPolicy
+
+	// MaxReported caps the number of malformed lines recorded in
+	// Result.Rejections with the Report policy. Defaults to 1000.
+	MaxReported int
+
+	// Percentiles keeps a histogram of every station's measurements, with a
+	// bucket per fixed-point unit, to report exact percentiles. Each
+	// histogram takes ~8 KB per station and worker, more if the measurements
+	// span a wider range, up to 16 MiB (4Mi buckets). That covers any range
+	// with one decimal, but only ~4,000 degrees with 3 (a unit conversion
+	// adds one, see InputUnit), ~400 with 4 and ~40 with 5: measurements
+	// beyond are counted in the edge buckets, and the percentiles of their
+	// station are no longer exact.
+	Percentiles bool
+
+	// Decimals is the precision of the temperatures. Zero is the challenge
+	// format, exactly one decimal, read by the fast parser. Any other value
+	// reads integers and any number of decimals, rounding the extra digits
+	// half away from zero, and validates every line: Unchecked behaves like
+	// Fail. NoDecimals reads whole degrees. At most 6 decimals are
+	// supported, 5 when converting units, so that ±999.9 degrees still fit
+	// the fixed-point values; measurements beyond them are rejected as out
+	// of range. More decimals make the Percentiles histograms larger, and
+	// less able to cover a wide range.
+	Decimals int
+
+	// InputUnit is the unit of the measurements, and StationUnits overrides
+	// it for individual stations (see LoadUnitMap). The measurements are
+	// converted to Celsius, with an extra decimal, before they are
+	// aggregated, and the results to OutputUnit. Any unit other than Celsius
+	// validates every line, as Decimals does.
+	InputUnit    Unit
+	OutputUnit   Unit
+	StationUnits map[string]Unit
+
+	// Delimiter separates the fields of a line. Defaults to ';'.
+	Delimiter byte
+
+	// KeyField and ValueField pick the station and temperature out of wider
+	// records. They are 1-based, like cut -f, default to 1 and 2 if zero,
+	// and have to be different fields. If both are zero, a line is split at
+	// its last delimiter, so station names may contain it; otherwise at every
+	// delimiter, ignoring the fields not picked. Anything but the default
+	// ';'-separated layout validates every line, as Decimals does.
+	KeyField, ValueField int
+}
+
+// NoDecimals is the Aggregator.Decimals for whole-degree temperatures.
+const NoDecimals = -1
+
+// Station is the aggregated result for a single station.
+type Station struct {
+	Name           string
+	Min, Mean, Max float64
+	Count          int
+
+	// Variance is the population variance, in degrees², and StdDev its
+	// square root.
+	Variance, StdDev float64
+
+	// Median (the 50th percentile), P90, P95 and P99 use the nearest-rank
+	// method, so they are always one of the measured values, unless the
+	// measurements span more than the histograms can cover (see
+	// Aggregator.Percentiles). They are only set when Aggregator.Percentiles
+	// is enabled.
+	Median, P90, P95, P99 float64
+
+	// the exact fixed-point values (in units of Result.Decimals), for output
+	// formats that need to round the mean in a particular way. After a unit
+	// conversion the sum is in units of 1/div of those, zero meaning 1.
+	min, max, sum, div int
+}
+
+// Result holds the aggregated stations, sorted by name.
+type Result struct {
+	Stations []Station
+
+	// Decimals is the number of decimals the temperatures are printed with,
+	// and Unit their unit.
+	Decimals int
+	Unit     Unit
+
+	// Rejected is the number of malformed lines dropped by the Skip and
+	// Report policies. Rejections holds the first of them, in input order,
+	// with the Report policy.
+	Rejected   int
+	Rejections []*ParseError
+
+	snapshot *Snapshot
+}
+
+// AggregateFile aggregates the measurements in the file at path. Regular
+// files are mmap-ed. Anything else (pipes, FIFOs, character devices) is read
+// through the same buffered pipeline as AggregateReader, and "-" is stdin.
+// Gzip and zstd compressed input is detected and decompressed on the fly.
+func (a *Aggregator) AggregateFile(path string) (*Result, error) {
+	return a.AggregateFiles(path)
+}
+
+// AggregateFiles is AggregateFile for several files, combined into a single
+// result as if they were one file. The chunks of all mmap-ed files are
+// handled by the same pool of workers. Use ExpandPaths to resolve globs and
+// directories first.
+func (a *Aggregator) AggregateFiles(paths ...string) (*Result, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		agg    = newAggregation()
+		mapped []mappedFile
+	)
+
+	defer func() {
+		for _, f := range mapped {
+			if f.data != nil {
+				syscall.Munmap(f.data)
+			}
+			if f.file != nil {
+				f.file.Close()
+			}
+		}
+	}()
+
+	for input, path := range paths {
+		f, stream, err := a.openFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if stream != nil {
+			agg.merge(stream, input)
+			continue
+		}
+
+		if f.size > 0 {
+			f.input = input
+			mapped = append(mapped, f)
+		}
+	}
+
+	a.aggregateMapped(agg, mapped)
+
+	return a.result(agg, a.conversion())
+}
+
+// AggregateReader reads r until EOF and aggregates its measurements. Reading
+// and parsing overlap, so r does not have to fit in memory. Like
+// AggregateFile, gzip and zstd compressed input is decompressed on the fly.
+func (a *Aggregator) AggregateReader(r io.Reader) (*Result, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	agg, err := a.aggregateCompressedStream(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return a.result(agg, a.conversion())
+}
+
+// result builds the result of agg, whose values conv converts, with the
+// rejections capped at MaxReported.
+func (a *Aggregator) result(agg *aggregation, conv conversion) (*Result, error) {
+	res, err := agg.result(conv)
+	if err != nil {
+		return nil, err
+	}
+
+	maxReported := a.MaxReported
+	if maxReported <= 0 {
+		maxReported = 1000
+	}
+
+	if len(res.Rejections) > maxReported {
+		res.Rejections = res.Rejections[:maxReported]
+	}
+
+	return res, nil
+}
+
+// parseChunk runs the parser selected by OnError over chunk. The offsets and
+// line numbers of the returned errors are relative to the chunk.
+func (a *Aggregator) parseChunk(batch *processedBatch, chunk []byte) (rejectedLines, error) {
+	checker := lineChecker{layout: a.layout(), parse: parseTempChecked, policy: a.OnError}
+	flexible := a.Decimals != 0 || a.converting()
+	if flexible {
+		decimals := a.internalDecimals()
+		checker.parse = func(s []byte) (int, bool) { return parseFixed(s, decimals) }
+	}
+
+	// the fast parser only knows the challenge format
+	if checker.policy == Unchecked && (flexible || checker.layout != defaultLayout) {
+		checker.policy = Fail
+	}
+
+	if checker.policy == Unchecked {
+		err := batch.addChunk(chunk)
+		if perr, ok := err.(*ParseError); ok {
+			perr.Line = bytes.Count(chunk[:perr.Offset], []byte{'\n'}) + 1
+		}
+
+		return rejectedLines{}, err
+	}
+
+	checker.maxReported = a.MaxReported
+	if checker.maxReported <= 0 {
+		checker.maxReported = 1000
+	}
+
+	return batch.addChunkChecked(chunk, &checker)
+}
+
+// layout is the effective Delimiter, KeyField and ValueField.
+func (a *Aggregator) layout() layout {
+	l := defaultLayout
+	if a.Delimiter != 0 {
+		l.delim = a.Delimiter
+	}
+
+	if a.KeyField != 0 || a.ValueField != 0 {
+		l.fields = true
+		if a.KeyField != 0 {
+			l.key = a.KeyField - 1
+		}
+		if a.ValueField != 0 {
+			l.value = a.ValueField - 1
+		}
+	}
+
+	return l
+}
+
+// Validate reports settings that cannot be aggregated with, such as the same
+// KeyField and ValueField. AggregateFiles and the other entry points return
+// its error before reading anything.
+func (a *Aggregator) Validate() error {
+	l := a.layout()
+	if a.KeyField < 0 || a.ValueField < 0 || l.key == l.value {
+		return fmt.Errorf("key field %d and value field %d must be different, non-negative fields", l.key+1, l.value+1)
+	}
+
+	if a.Decimals < NoDecimals {
+		return fmt.Errorf("invalid number of decimals %d", a.Decimals)
+	}
+	if a.internalDecimals() > maxDecimals {
+		return fmt.Errorf("at most %d decimals are supported, %d when converting units", maxDecimals, maxDecimals-1)
+	}
+
+	return nil
+}
+
+// decimals is the effective Decimals.
+func (a *Aggregator) decimals() int {
+	switch {
+	case a.Decimals == 0:
+		return 1
+	case a.Decimals < 0:
+		return 0
+	}
+
+	return a.Decimals
+}
+
+func (a *Aggregator) converting() bool {
+	if a.InputUnit != Celsius || a.OutputUnit != Celsius {
+		return true
+	}
+
+	for _, unit := range a.StationUnits {
+		if unit != Celsius {
+			return true
+		}
+	}
+
+	return false
+}
+
+// internalDecimals is the precision of the aggregated values: one decimal
+// more than the output when converting units, to keep the rounding of the
+// conversions out of the results, and at least hundredths for the offset of
+// Kelvin.
+func (a *Aggregator) internalDecimals() int {
+	if !a.converting() {
+		return a.decimals()
+	}
+
+	return max(a.decimals()+1, 2)
+}
+
+// conversion turns the aggregated values into the results.
+func (a *Aggregator) conversion() conversion {
+	return conversion{unit: a.OutputUnit, from: a.internalDecimals(), to: a.decimals()}
+}
+
+// toCelsius converts a measurement of station, at internalDecimals, to
+// Celsius.
+func (a *Aggregator) toCelsius(station []byte, temp int) int {
+	unit, ok := a.StationUnits[string(station)]
+	if !ok {
+		unit = a.InputUnit
+	}
+
+	if unit == Celsius {
+		return temp
+	}
+
+	return unit.toCelsius(temp, pow10(a.internalDecimals()))
+}
+
+func (a *Aggregator) newBatch() processedBatch {
+	batch := newProcessedBatch(defaultBatchSize)
+	batch.histograms = a.Percentiles
+	if a.converting() {
+		batch.convert = a.toCelsius
+	}
+
+	return batch
+}
+
+func (a *Aggregator) workers() int {
+	if a.Workers > 0 {
+		return a.Workers
+	}
+
+	return runtime.NumCPU()
+}
+
+// mappedFile is an uncompressed regular file, either mapped whole (data) or
+// left open for its segments to be read one at a time (file), see
+// MemoryBudget and Backend. The bytes from start, the start of a line, to
+// size are aggregated; start is only set by AggregateIncremental.
+type mappedFile struct {
+	input int
+	path  string
+	start int
+	size  int
+	data  []byte
+	file  *os.File
+}
+
+// linesBefore counts the lines before offset, for error messages. Files that
+// are not mapped whole are read up to offset, which is fine on the error
+// path; a read error only makes the count short.
+func (f mappedFile) linesBefore(offset int) int {
+	if f.data != nil {
+		return bytes.Count(f.data[:offset], []byte{'\n'})
+	}
+
+	var (
+		lines int
+		buf   = make([]byte, 256<<10)
+		r     = io.NewSectionReader(f.file, 0, int64(offset))
+	)
+
+	for {
+		n, err := r.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+// openFile mmaps path if it is an uncompressed regular file, or keeps it open
+// for its segments to be read one at a time with a MemoryBudget or another
+// Backend. Anything else is aggregated right away, and the result is
+// returned instead.
+func (a *Aggregator) openFile(path string) (mappedFile, *aggregation, error) {
+	if path == "-" {
+		agg, err := a.aggregateCompressedStream(os.Stdin, path)
+		return mappedFile{}, agg, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return mappedFile{}, nil, fileError("open", path, err)
+	}
+
+	keep := false
+	defer func() {
+		if !keep {
+			file.Close()
+		}
+	}()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return mappedFile{}, nil, fileError("stat", path, err)
+	}
+
+	if !stat.Mode().IsRegular() && !stat.IsDir() {
+		agg, err := a.aggregateCompressedStream(file, path)
+		return mappedFile{}, agg, err
+	}
+
+	if stat.Size() == 0 {
+		return mappedFile{path: path}, nil, nil
+	}
+
+	if a.MemoryBudget > 0 || a.Backend != Mmap {
+		head := make([]byte, 4)
+		n, err := file.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return mappedFile{}, nil, fileError("read", path, err)
+		}
+
+		if detectCompression(path, head[:n]) != uncompressed {
+			agg, err := a.aggregateCompressedStream(file, path)
+			return mappedFile{}, agg, err
+		}
+
+		keep = true
+		return mappedFile{path: path, size: int(stat.Size()), file: file}, nil, nil
+	}
+
+	data, err := syscall.Mmap(
+		int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED,
+	)
+	if err != nil {
+		return mappedFile{}, nil, &MmapError{Path: path, Err: err}
+	}
+
+	var agg *aggregation
+	switch detectCompression(path, data) {
+	case gzipped:
+		agg, err = a.aggregateGzip(data, path)
+	case zstdCompressed:
+		agg, err = a.aggregateDecompressed(bytes.NewReader(data), zstdCompressed, path)
+	default:
+		return mappedFile{path: path, size: len(data), data: data}, nil, nil
+	}
+
+	syscall.Munmap(data)
+	return mappedFile{}, agg, err
+}
+
+// chunkResult is what a chunk worker sends back on the fan-in channel.
+type chunkResult struct {
+	batch    processedBatch
+	err      error
+	rejected rejectedLines
+	input    int
+
+	// only set by the streaming pipeline, see aggregateStream
+	seq, lines int
+}
+
+type fileChunk struct {
+	file   mappedFile
+	offset int
+	data   []byte
+
+	// the nominal end of the segment, for files that are not mapped whole,
+	// before it is loaded, see load
+	end int
+}
+
+// aggregateMapped cuts the mmap-ed files into segments ending on a \n and
+// has a pool of workers take them one at a time, see segmenter. Each worker
+// keeps a single batch for all of its segments; the batches point into the
+// mapped files, which stay mapped until the result is built.
+func (a *Aggregator) aggregateMapped(agg *aggregation, files []mappedFile) {
+	if len(files) == 0 {
+		return
+	}
+
+	var (
+		workers = a.workers()
+		resChan = make(chan chunkResult, workers)
+		wg      sync.WaitGroup
+	)
+
+	size := a.SegmentSize
+	switch {
+	case size == PerWorkerSegments:
+		total := 0
+		for _, f := range files {
+			total += f.size
+		}
+		size = total/workers + 1
+	case size <= 0:
+		size = defaultSegmentSize
+	}
+
+	// every worker loads one window, of a segment and a little more, at a
+	// time
+	if a.MemoryBudget > 0 {
+		size = max(min(size, a.MemoryBudget/workers/2), minWindowSegment)
+	}
+	segments := newSegmenter(files, size)
+
+	// files that are not mapped whole are unmapped segment by segment
+	ownKeys := false
+	for _, f := range files {
+		ownKeys = ownKeys || f.data == nil
+	}
+
+	readers := make([]segmentReader, workers)
+	for i := range readers {
+		r, err := newSegmentReader(a.Backend)
+		if err != nil {
+			agg.addErr(err, files[0].input)
+			for _, r := range readers[:i] {
+				r.close()
+			}
+			return
+		}
+		readers[i] = r
+	}
+
+	wg.Add(workers)
+	for _, r := range readers {
+		go func(r segmentReader) {
+			defer wg.Done()
+			defer r.close()
+
+			batch := a.newBatch()
+			batch.ownKeys = ownKeys
+			for c, ok := segments.next(); ok; c, ok = segments.next() {
+				if c.data == nil {
+					var err error
+					if c, err = c.load(r); err != nil {
+						resChan <- chunkResult{err: err, input: c.file.input}
+						continue
+					}
+				}
+
+				rejected, err := a.parseChunk(&batch, c.data)
+				r.release()
+				if err == nil && rejected.count == 0 {
+					continue
+				}
+
+				// make the errors relative to the file
+				baseLine := c.file.linesBefore(c.offset)
+				perrs := rejected.lines
+				if perr, ok := err.(*ParseError); ok {
+					perrs = append(perrs, perr)
+				}
+				for _, perr := range perrs {
+					perr.Path = c.file.path
+					perr.Offset += int64(c.offset)
+					perr.Line += baseLine
+				}
+
+				resChan <- chunkResult{err: err, rejected: rejected, input: c.file.input}
+			}
+
+			resChan <- chunkResult{batch: batch}
+		}(r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resChan)
+	}()
+
+	for res := range resChan {
+		agg.add(res)
+	}
+}
+
+// aggregation merges the batches of all chunks into a single result.
+// Station names are copied out of the batches since they point into the
+// input buffer, which may be unmapped once aggregation is done. Every chunk
+// has to be added even if one of them failed, and the error closest to the
+// start of the input is returned.
+type aggregation struct {
+	aggData     map[string]temprature
+	stationList []string
+	firstErr    error
+	errInput    int
+
+	rejected   int
+	rejections []rejection
+}
+
+type rejection struct {
+	input int
+	err   *ParseError
+}
+
+func newAggregation() *aggregation {
+	return &aggregation{
+		aggData:     make(map[string]temprature, 100000),
+		stationList: make([]string, 0, 413),
+	}
+}
+
+func (agg *aggregation) add(res chunkResult) {
+	agg.rejected += res.rejected.count
+	for _, perr := range res.rejected.lines {
+		agg.rejections = append(agg.rejections, rejection{res.input, perr})
+	}
+
+	if res.err != nil {
+		agg.addErr(res.err, res.input)
+		return
+	}
+
+	for _, temp := range res.batch.slots {
+		if temp.count == 0 {
+			continue
+		}
+
+		station := unsafe.String(unsafe.SliceData(temp.key), len(temp.key))
+		agg.addStation(station, temp)
+	}
+}
+
+// merge adds everything other aggregated from input.
+func (agg *aggregation) merge(other *aggregation, input int) {
+	if other.firstErr != nil {
+		agg.addErr(other.firstErr, input)
+	}
+
+	agg.rejected += other.rejected
+	for _, r := range other.rejections {
+		agg.rejections = append(agg.rejections, rejection{input, r.err})
+	}
+
+	for _, station := range other.stationList {
+		agg.addStation(station, other.aggData[station])
+	}
+}
+
+func (agg *aggregation) addErr(err error, input int) {
+	if agg.firstErr == nil || input < agg.errInput ||
+		input == agg.errInput && errOffset(err) < errOffset(agg.firstErr) {
+		agg.firstErr = err
+		agg.errInput = input
+	}
+}
+
+func (agg *aggregation) addStation(station string, temp temprature) {
+	if row, ok := agg.aggData[station]; !ok {
+		station = strings.Clone(station)
+		temp.key = nil
+		agg.aggData[station] = temp
+		agg.stationList = append(agg.stationList, station)
+	} else {
+		row.min = min(row.min, temp.min)
+		row.max = max(row.max, temp.max)
+		row.sum += temp.sum
+		row.sumSq.add(temp.sumSq)
+		row.count += temp.count
+		if row.hist != nil {
+			row.hist.merge(temp.hist)
+		}
+		agg.aggData[station] = row
+	}
+}
+
+func (agg *aggregation) result(conv conversion) (*Result, error) {
+	if agg.firstErr != nil {
+		return nil, agg.firstErr
+	}
+
+	sort.Strings(agg.stationList)
+	res := &Result{
+		Stations: make([]Station, 0, len(agg.stationList)),
+		Decimals: conv.to,
+		Unit:     conv.unit,
+	}
+	res.snapshot = &Snapshot{agg: agg, conv: conv, histograms: true}
+
+	scale := float64(pow10(conv.to))
+	factor := conv.factor()
+	for _, station := range agg.stationList {
+		data := agg.aggData[station]
+		if data.hist == nil {
+			res.snapshot.histograms = false
+		}
+		sum, div := conv.sum(data.sum, data.count)
+		row := Station{
+			Name:  station,
+			Min:   float64(conv.value(data.min)) / scale,
+			Mean:  (float64(sum) / float64(data.count*div)) / scale,
+			Max:   float64(conv.value(data.max)) / scale,
+			Count: data.count,
+			min:   conv.value(data.min),
+			max:   conv.value(data.max),
+			sum:   sum,
+		}
+		if div != 1 {
+			row.div = div
+		}
+		row.Variance = variance(data.sum, data.sumSq, data.count, conv.from) * factor * factor
+		row.StdDev = stdDev(row.Variance)
+
+		if data.hist != nil {
+			row.Median = float64(conv.value(data.hist.percentile(50, data.count))) / scale
+			row.P90 = float64(conv.value(data.hist.percentile(90, data.count))) / scale
+			row.P95 = float64(conv.value(data.hist.percentile(95, data.count))) / scale
+			row.P99 = float64(conv.value(data.hist.percentile(99, data.count))) / scale
+		}
+
+		res.Stations = append(res.Stations, row)
+	}
+
+	res.Rejected = agg.rejected
+	sort.Slice(agg.rejections, func(i, j int) bool {
+		a, b := agg.rejections[i], agg.rejections[j]
+		return a.input < b.input || a.input == b.input && a.err.Offset < b.err.Offset
+	})
+	for _, r := range agg.rejections {
+		res.Rejections = append(res.Rejections, r.err)
+	}
+
+	return res, nil
+}
+
+func errOffset(err error) int64 {
+	if perr, ok := err.(*ParseError); ok {
+		return perr.Offset
+	}
+
+	return math.MaxInt64
+}
@@ -0,0 +1,136 @@
+package brc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateReader(t *testing.T) {
+	in := "Banjul;38.9\nHamilton;9.5\nBanjul;-38.9\nJos;3.9\nHamilton;10.3\nJos;3.9"
+
+	for _, workers := range []int{1, 2, 3, 7, 100} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			agg := Aggregator{Workers: workers}
+
+			res, err := agg.AggregateReader(strings.NewReader(in))
+			require.NoError(t, err)
+
+			assert.Equal(t, []Station{
+				{Name: "Banjul", Min: -38.9, Mean: 0, Max: 38.9, Count: 2, Variance: 1513.21, StdDev: 38.9, min: -389, max: 389},
+				{Name: "Hamilton", Min: 9.5, Mean: 9.9, Max: 10.3, Count: 2, Variance: 0.16, StdDev: 0.4, min: 95, max: 103, sum: 198},
+				{Name: "Jos", Min: 3.9, Mean: 3.9, Max: 3.9, Count: 2, min: 39, max: 39, sum: 78},
+			}, res.Stations)
+		})
+	}
+}
+
+func TestAggregateFile(t *testing.T) {
+	var agg Aggregator
+
+	fromFile, err := agg.AggregateFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+	assert.Len(t, fromFile.Stations, 413)
+
+	file, err := os.Open("testdata/sample_data.txt")
+	require.NoError(t, err)
+	defer file.Close()
+
+	fromReader, err := agg.AggregateReader(file)
+	require.NoError(t, err)
+	assert.Equal(t, fromFile, fromReader)
+}
+
+func TestAggregateErrors(t *testing.T) {
+	tt := []struct {
+		in     string
+		offset int64
+		line   int
+		reason string
+	}{
+		{"Jos;3.9\nAbcdefg\nJos;3.9\n", 8, 2, "missing ';'"},
+		{"Jos;3.9\nJos;3.9\nAbc;1\n", 16, 3, `invalid temperature "1"`},
+		{"Jos;3.9\nAbc;-.1\n", 8, 2, `invalid temperature "-.1"`},
+		{"Jos;3.9\nab", 8, 2, "line too short"},
+	}
+
+	for i, tc := range tt {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprint(i, "/", workers), func(t *testing.T) {
+				agg := Aggregator{Workers: workers}
+
+				_, err := agg.AggregateReader(strings.NewReader(tc.in))
+
+				var perr *ParseError
+				require.ErrorAs(t, err, &perr)
+				assert.Equal(t, tc.offset, perr.Offset)
+				assert.Equal(t, tc.line, perr.Line)
+				assert.Equal(t, tc.reason, perr.Reason)
+			})
+		}
+	}
+}
+
+func TestAggregateFileErrors(t *testing.T) {
+	var agg Aggregator
+
+	_, err := agg.AggregateFile("testdata/does_not_exist.txt")
+	var ferr *FileError
+	require.ErrorAs(t, err, &ferr)
+	assert.Equal(t, "open", ferr.Op)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// directories can be opened and stat-ed, but not mapped
+	_, err = agg.AggregateFile("testdata")
+	var merr *MmapError
+	assert.ErrorAs(t, err, &merr)
+}
+
+func TestAggregateFiles(t *testing.T) {
+	var agg Aggregator
+	want, err := agg.AggregateFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	// split on line boundaries, with an empty and a compressed part
+	lines := strings.SplitAfter(string(plain), "\n")
+	dir := t.TempDir()
+	parts := []string{
+		strings.Join(lines[:100], ""),
+		"",
+		strings.Join(lines[100:101], ""),
+		string(gzipMembers(t, []byte(strings.Join(lines[101:300], "")), 2, 6)),
+		strings.Join(lines[300:], ""),
+	}
+
+	var paths []string
+	for i, part := range parts {
+		path := filepath.Join(dir, fmt.Sprintf("part-%d", i))
+		require.NoError(t, os.WriteFile(path, []byte(part), 0o600))
+		paths = append(paths, path)
+	}
+
+	for _, workers := range []int{1, 2, 16} {
+		agg := Aggregator{Workers: workers}
+
+		got, err := agg.AggregateFiles(paths...)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	bad := filepath.Join(dir, "bad")
+	require.NoError(t, os.WriteFile(bad, []byte("Jos;3.9\nAbcdefg\n"), 0o600))
+
+	_, err = agg.AggregateFiles(append(paths, bad)...)
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, bad, perr.Path)
+	assert.Equal(t, 2, perr.Line)
+}
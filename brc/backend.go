@@ -0,0 +1,90 @@
+package brc
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// IOBackend selects how regular files are read.
+type IOBackend int
+
+const (
+	// Mmap maps files whole, or a window per segment with a MemoryBudget.
+	Mmap IOBackend = iota
+
+	// Pread reads every segment into a buffer of its worker with pread(2).
+	Pread
+
+	// IOUring reads every segment into a buffer of its worker with
+	// io_uring(7), split into pieces that are read concurrently. Linux only.
+	IOUring
+)
+
+var ioBackendNames = map[IOBackend]string{
+	Mmap:    "mmap",
+	Pread:   "pread",
+	IOUring: "io_uring",
+}
+
+func (b IOBackend) String() string {
+	if name, ok := ioBackendNames[b]; ok {
+		return name
+	}
+
+	return "IOBackend(" + strconv.Itoa(int(b)) + ")"
+}
+
+// ParseIOBackend is the inverse of IOBackend.String.
+func ParseIOBackend(name string) (IOBackend, error) {
+	for b, n := range ioBackendNames {
+		if n == name {
+			return b, nil
+		}
+	}
+
+	return Mmap, fmt.Errorf("unknown I/O backend %q", name)
+}
+
+// segmentReader reads the windows of files that are not mapped whole, for a
+// single worker, see fileChunk.load. A window is valid until release, which
+// comes before the next read.
+type segmentReader interface {
+	read(f mappedFile, lo, hi int) ([]byte, error)
+	release()
+	close() error
+}
+
+func newSegmentReader(backend IOBackend) (segmentReader, error) {
+	switch backend {
+	case Pread:
+		return &preadReader{}, nil
+	case IOUring:
+		return newURingReader()
+	}
+
+	return &mmapReader{}, nil
+}
+
+// preadReader reads windows into a buffer that is reused.
+type preadReader struct {
+	buf []byte
+}
+
+func (r *preadReader) read(f mappedFile, lo, hi int) ([]byte, error) {
+	if cap(r.buf) < hi-lo {
+		r.buf = make([]byte, hi-lo)
+	}
+
+	// a short read means the file was truncated while it was read
+	n, err := f.file.ReadAt(r.buf[:hi-lo], int64(lo))
+	if err != nil && err != io.EOF {
+		return nil, fileError("read", f.path, err)
+	}
+
+	return r.buf[:n], nil
+}
+
+func (r *preadReader) release() {}
+
+func (r *preadReader) close() error { return nil }
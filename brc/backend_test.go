@@ -0,0 +1,66 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// skipWithoutIOURing skips tests of the io_uring backend where the kernel
+// does not have it or does not allow it (e.g. under seccomp).
+func skipWithoutIOURing(t testing.TB) {
+	r, err := newSegmentReader(IOUring)
+	if err == nil {
+		r.close()
+		return
+	}
+
+	if errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EPERM) || errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("io_uring is not available:", err)
+	}
+	t.Fatal(err)
+}
+
+func TestBackends(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte(windowedInput()), 0o600))
+
+	small := filepath.Join(dir, "small.txt")
+	require.NoError(t, os.WriteFile(small, []byte("Jos;3.9\nJos;x"), 0o600))
+
+	want, err := (&Aggregator{OnError: Report}).AggregateFiles(path, small)
+	require.NoError(t, err)
+
+	for _, backend := range []IOBackend{Mmap, Pread, IOUring} {
+		for _, budget := range []int{0, 1} {
+			t.Run(fmt.Sprintf("%s/%d", backend, budget), func(t *testing.T) {
+				if backend == IOUring {
+					skipWithoutIOURing(t)
+				}
+
+				agg := Aggregator{Workers: 3, Backend: backend, MemoryBudget: budget, OnError: Report}
+				res, err := agg.AggregateFiles(path, small)
+				require.NoError(t, err)
+				assert.Equal(t, want, res)
+			})
+		}
+	}
+}
+
+func TestParseIOBackend(t *testing.T) {
+	for _, b := range []IOBackend{Mmap, Pread, IOUring} {
+		got, err := ParseIOBackend(b.String())
+		require.NoError(t, err)
+		assert.Equal(t, b, got)
+	}
+
+	_, err := ParseIOBackend("aio")
+	assert.Error(t, err)
+}
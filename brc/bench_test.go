@@ -0,0 +1,333 @@
+package brc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func BenchmarkSplit(b *testing.B) {
+	s := []byte("foo,bar,baz")
+
+	result1 := make([][]byte, 3)
+	b.Run("stdlib", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			result1 = bytes.Split(s, []byte(","))
+		}
+	})
+
+	result2 := make([][]byte, 3)
+	b.Run("custom", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			startIdx := 0
+			insertIdx := 0
+			for i, c := range s {
+				if c == ',' {
+					result2[insertIdx] = s[startIdx:i]
+					startIdx = i + 1
+					insertIdx++
+					continue
+				}
+			}
+
+			result2[insertIdx] = s[startIdx:]
+		}
+	})
+
+	assert.Equal(b, result1, result2)
+}
+
+func BenchmarkBytesToStr(b *testing.B) {
+	byteData := []byte("hello world")
+
+	b.Run("string()", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = string(byteData)
+		}
+	})
+
+	b.Run("unsafe", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = unsafe.String(&byteData[0], len(byteData))
+		}
+	})
+}
+
+func BenchmarkParseTemprature(b *testing.B) {
+	temp := []byte("123")
+
+	b.Run("Using strconv.Atoi", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = strconv.Atoi(string(temp))
+		}
+	})
+
+	b.Run("Transposing ASCII chars", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = parseTempBytewise(temp)
+		}
+	})
+
+	// swarTemp reads a whole word, so it is given the rest of the line as
+	// addChunk does rather than a 3-byte slice to pad
+	line := []byte("12.3\nAbc;")
+	b.Run("SWAR", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _, _ = swarTemp(loadWord(line))
+		}
+	})
+}
+
+// parseTempBytewise is parseTemp before swarTemp.
+func parseTempBytewise(s []byte) int {
+	start := 0
+	mul := 1
+	if s[0] == '-' {
+		start = 1
+		mul = -1
+	}
+
+	if len(s[start:]) == 3 {
+		return (((int(s[start]) - 48) * 10) + (int(s[start+2]) - 48)) * mul
+	}
+
+	return (((int(s[start]) - 48) * 100) + ((int(s[start+1]) - 48) * 10) + (int(s[start+3]) - 48)) * mul
+}
+
+// addChunkBytewise is addChunk before the SWAR scanning: byte by byte to
+// the \n, then backwards to the ';'.
+func (localData *processedBatch) addChunkBytewise(chunk []byte) error {
+	var (
+		start, end, splitIdx int
+		line, temp           []byte
+
+		chunkLen = len(chunk)
+	)
+
+	for end < chunkLen {
+		if chunk[end] == '\n' || end == chunkLen-1 {
+			if end == chunkLen-1 && chunk[end] != '\n' {
+				end = chunkLen
+			}
+
+			line = trimLineEnd(chunk[start:end])
+			for splitIdx = len(line) - 4; splitIdx >= 0; splitIdx-- {
+				if line[splitIdx] == ';' {
+					break
+				}
+			}
+
+			if splitIdx < 0 {
+				return lineError(line, start)
+			}
+
+			temp = line[splitIdx+1:]
+			if !validTempLen(temp) {
+				return lineError(line, start)
+			}
+
+			localData.add(line[:splitIdx], parseTempBytewise(temp))
+
+			start = end + 1
+			end += 6 // smallest possible line
+			continue
+		}
+
+		end++
+	}
+
+	if start < chunkLen {
+		return lineError(chunk[start:], start)
+	}
+
+	return nil
+}
+
+// BenchmarkScanChunk compares the SWAR scanning of addChunk with the byte
+// by byte scanning it replaced, on testdata/sample_data.txt.
+func BenchmarkScanChunk(b *testing.B) {
+	data, err := os.ReadFile("testdata/sample_data.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, impl := range []struct {
+		name string
+		add  func(*processedBatch, []byte) error
+	}{
+		{"bytewise", (*processedBatch).addChunkBytewise},
+		{"SWAR", (*processedBatch).addChunk},
+	} {
+		b.Run(impl.name, func(b *testing.B) {
+			batch := newProcessedBatch(defaultBatchSize)
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				if err := impl.add(&batch, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMapInit(b *testing.B) {
+	var (
+		m1 = make(map[string]int)
+		m2 = make(map[string]int, 1000)
+	)
+
+	b.Run("With default initial size", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1000; j++ {
+				m1[strconv.Itoa(j)] = j
+			}
+		}
+	})
+
+	b.Run("With big initial size", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1000; j++ {
+				m2[strconv.Itoa(j)] = j
+			}
+		}
+	})
+}
+
+// benchFile writes ~32 MiB of measurements of the 413 cities. If skewed, the
+// first quarter is much slower to aggregate than the rest (thousands of long,
+// distinct station names instead), which stalls whoever gets it in a split of
+// one chunk per worker.
+func benchFile(b *testing.B, skewed bool) string {
+	cities, err := os.ReadFile("testdata/cities")
+	if err != nil {
+		b.Fatal(err)
+	}
+	names := bytes.Split(bytes.TrimSpace(cities), []byte("\n"))
+
+	path := filepath.Join(b.TempDir(), "skewed.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	const size = 32 << 20
+	w := bufio.NewWriter(f)
+	for i, n := 0, 0; n < size; i++ {
+		var m int
+		if skewed && n < size/4 {
+			m, _ = fmt.Fprintf(w, "%s station number %d;%d.%d\n", names[i%len(names)], i%29, i%100-50, i%10)
+		} else {
+			m, _ = fmt.Fprintf(w, "%s;%d.%d\n", names[i%len(names)], i%100-50, i%10)
+		}
+		n += m
+	}
+
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+
+	return path
+}
+
+// BenchmarkScheduler compares the fixed-size segments taken from a shared
+// cursor with one segment per worker, the split used before.
+func BenchmarkScheduler(b *testing.B) {
+	path := benchFile(b, true)
+	stat, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{PerWorkerSegments, 256 << 10, 1 << 20, 4 << 20} {
+		name := "per-worker"
+		if size > 0 {
+			name = fmt.Sprintf("%dKiB", size>>10)
+		}
+
+		b.Run(name, func(b *testing.B) {
+			agg := Aggregator{SegmentSize: size}
+			b.SetBytes(stat.Size())
+
+			for i := 0; i < b.N; i++ {
+				if _, err := agg.AggregateFile(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGzipMembers compares a gzip file made of a single member, which
+// is decompressed on one core, with one made of many, which are decompressed
+// and aggregated in parallel.
+func BenchmarkGzipMembers(b *testing.B) {
+	plain, err := os.ReadFile(benchFile(b, false))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, members := range []int{1, 4 * runtime.NumCPU()} {
+		path := filepath.Join(b.TempDir(), "measurements.gz")
+		if err := os.WriteFile(path, gzipMembers(b, plain, members, gzip.DefaultCompression), 0o600); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("%d-members", members), func(b *testing.B) {
+			var agg Aggregator
+			b.SetBytes(int64(len(plain)))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := agg.AggregateFile(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBackends compares the I/O backends with the file in the page cache
+// (warm) and evicted from it before every run (cold). The eviction only works
+// on Linux; elsewhere the cold runs are skipped.
+func BenchmarkBackends(b *testing.B) {
+	path := benchFile(b, false)
+	stat, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, backend := range []IOBackend{Mmap, Pread, IOUring} {
+		for _, cache := range []string{"warm", "cold"} {
+			b.Run(backend.String()+"/"+cache, func(b *testing.B) {
+				if backend == IOUring {
+					skipWithoutIOURing(b)
+				}
+
+				agg := Aggregator{Backend: backend}
+				b.SetBytes(stat.Size())
+
+				for i := 0; i < b.N; i++ {
+					if cache == "cold" {
+						b.StopTimer()
+						dropPageCache(b, path)
+						b.StartTimer()
+					}
+
+					if _, err := agg.AggregateFile(path); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
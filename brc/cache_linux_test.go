@@ -0,0 +1,22 @@
+package brc
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// dropPageCache evicts the clean pages of the file at path from the page
+// cache with posix_fadvise(POSIX_FADV_DONTNEED), which needs no privileges.
+func dropPageCache(b *testing.B, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	const fadvDontNeed = 4
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontNeed, 0, 0); errno != 0 {
+		b.Fatal(errno)
+	}
+}
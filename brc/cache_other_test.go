@@ -0,0 +1,9 @@
+//go:build !linux
+
+package brc
+
+import "testing"
+
+func dropPageCache(b *testing.B, path string) {
+	b.Skip("evicting a file from the page cache is only implemented on Linux")
+}
@@ -0,0 +1,155 @@
+package brc
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// parseTemp expects a temperature that has already passed validTempLen. It
+// is decoded by swarTemp, which gives the expected result for [-]d.d and
+// [-]dd.d and garbage for anything else.
+func parseTemp(s []byte) int {
+	temp, _, _ := swarTemp(loadWord(s))
+	return temp
+}
+
+// validTempLen reports whether s is long enough for parseTemp to index into
+// it. Neither the digits nor any extra trailing bytes are checked.
+func validTempLen(s []byte) bool {
+	n := len(s)
+	if n > 0 && s[0] == '-' {
+		n--
+	}
+
+	return n >= 3
+}
+
+// handleChunk aggregates every line in chunk into a new batch, without
+// histograms. It stops at the first line it cannot split, returning a
+// *ParseError whose Offset is relative to the start of the chunk and whose
+// Line is left for the caller to fill in.
+func handleChunk(chunk []byte) (processedBatch, error) {
+	localData := newProcessedBatch(defaultBatchSize)
+	err := localData.addChunk(chunk)
+
+	return localData, err
+}
+
+// addChunk is handleChunk for a batch that is reused across chunks. Lines
+// are scanned eight bytes at a time for the ';' (see swar.go), and the
+// temperature after it is decoded by swarTemp. Lines that do not fit that
+// fast path (a \r\n ending, trailing whitespace, an unexpected temperature or
+// a malformed line) go through addLine.
+func (localData *processedBatch) addChunk(chunk []byte) error {
+	for start := 0; start < len(chunk); {
+		// the first ';' or '\n'
+		sep := start
+		for sep < len(chunk) {
+			word := loadWord(chunk[sep:])
+			if m := swarMatch(word, semicolons) | swarMatch(word, newlines); m != 0 {
+				sep += swarIndex(m)
+				break
+			}
+			sep += 8
+		}
+
+		if sep < len(chunk) && chunk[sep] == ';' {
+			rest := chunk[sep+1:]
+			temp, n, ok := swarTemp(loadWord(rest))
+			if ok && (n == len(rest) || rest[n] == '\n') {
+				localData.add(chunk[start:sep], temp)
+				start = sep + n + 2
+				continue
+			}
+		}
+
+		next, err := localData.addLine(chunk, start)
+		if err != nil {
+			return err
+		}
+		start = next
+	}
+
+	return nil
+}
+
+// addLine parses the line at start the way addChunk used to, byte by byte:
+// the temperature is whatever follows the last ';' in the last 6 bytes of the
+// line, and is only checked for length. It returns the start of the next
+// line.
+func (localData *processedBatch) addLine(chunk []byte, start int) (int, error) {
+	end := bytes.IndexByte(chunk[start:], '\n')
+	if end < 0 {
+		end = len(chunk)
+	} else {
+		end += start
+	}
+
+	line := trimLineEnd(chunk[start:end])
+	splitIdx := len(line) - 4
+	for ; splitIdx >= 0; splitIdx-- {
+		if line[splitIdx] == ';' {
+			break
+		}
+	}
+
+	if splitIdx < 0 {
+		return 0, lineError(line, start)
+	}
+
+	temp := line[splitIdx+1:]
+	if !validTempLen(temp) {
+		return 0, lineError(line, start)
+	}
+
+	localData.add(line[:splitIdx], parseTemp(temp))
+
+	return end + 1, nil
+}
+
+// trimLineEnd drops the \r of a \r\n line ending and any trailing spaces
+// and tabs.
+func trimLineEnd(line []byte) []byte {
+	for len(line) > 0 {
+		switch line[len(line)-1] {
+		case '\r', ' ', '\t':
+			line = line[:len(line)-1]
+			continue
+		}
+
+		break
+	}
+
+	return line
+}
+
+// utf8BOM is the byte order mark some tools write at the start of UTF-8
+// files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// bomLen is the length of the byte order mark at the start of data, if any.
+func bomLen(data []byte) int {
+	if bytes.HasPrefix(data, utf8BOM) {
+		return len(utf8BOM)
+	}
+
+	return 0
+}
+
+// lineError works out why handleChunk rejected line. It is only called on the
+// error path, so it does not need to be fast.
+func lineError(line []byte, offset int) *ParseError {
+	err := &ParseError{Offset: int64(offset)}
+
+	splitIdx := bytes.LastIndexByte(line, ';')
+	switch {
+	case len(line) < 5: // smallest possible line
+		err.Reason = "line too short"
+	case splitIdx < 0:
+		err.Reason = "missing ';'"
+	default:
+		err.Reason = "invalid temperature " + strconv.Quote(string(line[splitIdx+1:]))
+	}
+
+	return err
+}
@@ -0,0 +1,354 @@
+package brc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type compression int
+
+const (
+	uncompressed compression = iota
+	gzipped
+	zstdCompressed
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression looks at the first bytes of the input, falling back to
+// the file extension so that a truncated or empty archive still fails loudly
+// instead of being parsed as text.
+func detectCompression(path string, head []byte) compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzipped
+	case bytes.HasPrefix(head, zstdMagic):
+		return zstdCompressed
+	}
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		return gzipped
+	case ".zst":
+		return zstdCompressed
+	}
+
+	return uncompressed
+}
+
+// aggregateCompressedStream peeks at r and decompresses it on the fly if
+// needed, before handing it over to aggregateStream.
+func (a *Aggregator) aggregateCompressedStream(r io.Reader, path string) (*aggregation, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	head, _ := br.Peek(len(zstdMagic))
+
+	return a.aggregateDecompressed(br, detectCompression(path, head), path)
+}
+
+func (a *Aggregator) aggregateDecompressed(r io.Reader, kind compression, path string) (*aggregation, error) {
+	switch kind {
+	case gzipped:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fileError("decompress", path, err)
+		}
+		defer zr.Close()
+
+		return a.aggregateStream(decompressReader{zr}, path)
+
+	case zstdCompressed:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(a.workers()))
+		if err != nil {
+			return nil, fileError("decompress", path, err)
+		}
+		defer zr.Close()
+
+		return a.aggregateStream(decompressReader{zr}, path)
+	}
+
+	return a.aggregateStream(r, path)
+}
+
+// decompressReader tags errors from the decompressor, so they are reported
+// as "decompress" instead of "read" failures.
+type decompressReader struct {
+	r io.Reader
+}
+
+type decompressError struct {
+	err error
+}
+
+func (e decompressError) Error() string { return e.err.Error() }
+
+func (r decompressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = decompressError{err}
+	}
+
+	return n, err
+}
+
+// gzipMember is the speculative aggregation of a member that may start at
+// offset. It stops early once done is closed.
+type gzipMember struct {
+	offset int
+	done   chan struct{}
+	result chan gzipMemberResult
+}
+
+// gzipMemberResult is what a member adds to the aggregation. Its lines are
+// aggregated, except for the partial ones at its edges, which may continue
+// in the neighbouring members: head runs up to and including the first \n
+// (or is the whole member if there is none) and tail follows the last one.
+// The offsets and line numbers of its malformed lines count from the start
+// of the member.
+type gzipMemberResult struct {
+	agg        *aggregation
+	head, tail []byte
+	size       int // decompressed bytes
+	lines      int // \n in the member
+	end        int // offset right after the member
+	err        error
+}
+
+// aggregateGzip aggregates a mmap-ed gzip file. Files made of several
+// members (e.g. cat-ed together) are aggregated in parallel
+// since every member is independent. Member boundaries are not known up
+// front, so every offset that looks like a member header is decompressed and
+// aggregated speculatively, up to Workers of them at a time, each through its
+// own buffers. The members are then stitched together in order: starting at
+// offset 0, the member that starts where the previous one ended is added,
+// along with the line that straddles them, and every candidate that started
+// inside it turns out to be a false positive and is dropped.
+func (a *Aggregator) aggregateGzip(data []byte, path string) (*aggregation, error) {
+	candidates := gzipCandidates(data)
+	if len(candidates) == 0 || candidates[0] != 0 {
+		return nil, fileError("decompress", path, gzip.ErrHeader)
+	}
+
+	var (
+		agg      = newAggregation()
+		window   = a.workers()
+		inflight []*gzipMember
+		next     int // next candidate to launch
+		cur      int // offset of the next member
+
+		// the decompressed bytes and lines before the next member, and the
+		// partial line at the end of the previous ones
+		offset, lines int
+		carry         []byte
+	)
+
+	// data is unmapped once we return, so the speculative members have to
+	// be done with it
+	defer func() {
+		for _, m := range inflight {
+			close(m.done)
+			<-m.result
+		}
+	}()
+
+	for cur < len(data) {
+		// drop the candidates that started inside the previous member
+		for len(inflight) > 0 && inflight[0].offset < cur {
+			close(inflight[0].done)
+			<-inflight[0].result
+			inflight = inflight[1:]
+		}
+		for next < len(candidates) && candidates[next] < cur {
+			next++
+		}
+
+		// keep the window full, always including the member at cur
+		for next < len(candidates) && (len(inflight) < window || candidates[next] == cur) {
+			inflight = append(inflight, a.startGzipMember(data, candidates[next]))
+			next++
+		}
+
+		if len(inflight) == 0 || inflight[0].offset != cur {
+			return nil, fileError("decompress", path, gzip.ErrHeader)
+		}
+
+		member := inflight[0]
+		inflight = inflight[1:]
+		res := <-member.result
+		close(member.done)
+		if res.err != nil {
+			return nil, fileError("decompress", path, res.err)
+		}
+
+		if res.lines > 0 {
+			line := append(carry, res.head...)
+			a.addStreamChunk(agg, streamChunk{offset: int64(offset - len(carry)), data: line}, lines)
+			carry = res.tail
+		} else {
+			carry = append(carry, res.head...)
+		}
+
+		for _, r := range res.agg.rejections {
+			r.err.Offset += int64(offset)
+			r.err.Line += lines
+		}
+		if perr, ok := res.agg.firstErr.(*ParseError); ok {
+			perr.Offset += int64(offset)
+			perr.Line += lines
+		}
+		agg.merge(res.agg, 0)
+
+		offset += res.size
+		lines += res.lines
+		cur = res.end
+	}
+
+	if len(carry) > 0 {
+		a.addStreamChunk(agg, streamChunk{offset: int64(offset - len(carry)), data: carry}, lines)
+	}
+
+	for _, r := range agg.rejections {
+		r.err.Path = path
+	}
+	if perr, ok := agg.firstErr.(*ParseError); ok {
+		perr.Path = path
+	}
+
+	return agg, nil
+}
+
+// startGzipMember aggregates the single member starting at offset in the
+// background.
+func (a *Aggregator) startGzipMember(data []byte, offset int) *gzipMember {
+	m := &gzipMember{
+		offset: offset,
+		done:   make(chan struct{}),
+		result: make(chan gzipMemberResult, 1),
+	}
+
+	go func() {
+		m.result <- a.aggregateGzipMember(data, offset, m.done)
+	}()
+
+	return m
+}
+
+func (a *Aggregator) aggregateGzipMember(data []byte, offset int, done <-chan struct{}) gzipMemberResult {
+	// bytes.Reader is an io.ByteReader, so the gzip reader does not read past
+	// the end of the member and the remaining length tells us where it ended
+	br := bytes.NewReader(data[offset:])
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return gzipMemberResult{err: err}
+	}
+	zr.Multistream(false)
+
+	chunks := make(chan streamChunk, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		readErr <- a.readChunks(cancelReader{zr, done}, chunks)
+	}()
+
+	// the chunks are parsed on this goroutine: the other members keep the
+	// other workers busy
+	res := gzipMemberResult{agg: &aggregation{aggData: make(map[string]temprature)}}
+	for c := range chunks {
+		select {
+		case <-done:
+			continue
+		default:
+		}
+
+		res.size += len(c.data)
+		lines := res.lines
+		res.lines += bytes.Count(c.data, []byte{'\n'})
+
+		body := c.data
+		if c.seq == 0 {
+			i := bytes.IndexByte(body, '\n') + 1
+			if i == 0 {
+				i = len(body)
+			}
+
+			res.head = bytes.Clone(body[:i])
+			body = body[i:]
+			c.offset += int64(i)
+			lines += bytes.Count(res.head, []byte{'\n'})
+		}
+
+		// only the last chunk can end without a \n
+		last := bytes.LastIndexByte(body, '\n') + 1
+		res.tail = bytes.Clone(body[last:])
+		if last > 0 {
+			a.addStreamChunk(res.agg, streamChunk{offset: c.offset, data: body[:last]}, lines)
+		}
+	}
+
+	if err := <-readErr; err != nil {
+		return gzipMemberResult{err: err}
+	}
+
+	res.end = offset + len(data[offset:]) - br.Len()
+	return res
+}
+
+// addStreamChunk aggregates c into agg, numbering its lines from lines+1.
+func (a *Aggregator) addStreamChunk(agg *aggregation, c streamChunk, lines int) {
+	res := a.handleStreamChunk(c)
+	for _, perr := range res.rejected.lines {
+		perr.Line += lines
+	}
+	if perr, ok := res.err.(*ParseError); ok {
+		perr.Line += lines
+	}
+
+	agg.add(res)
+}
+
+// cancelReader stops reading from r once done is closed.
+type cancelReader struct {
+	r    io.Reader
+	done <-chan struct{}
+}
+
+var errCancelled = errors.New("cancelled")
+
+func (r cancelReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.done:
+		return 0, errCancelled
+	default:
+	}
+
+	return r.r.Read(p)
+}
+
+// gzipCandidates returns every offset that starts with a plausible member
+// header: the magic, the deflate method and no reserved flag bits.
+func gzipCandidates(data []byte) []int {
+	var offsets []int
+
+	for i := 0; i+10 <= len(data); {
+		idx := bytes.Index(data[i:], gzipMagic)
+		if idx < 0 {
+			break
+		}
+
+		i += idx
+		if i+10 <= len(data) && data[i+2] == 8 && data[i+3]&0xe0 == 0 {
+			offsets = append(offsets, i)
+		}
+		i++
+	}
+
+	return offsets
+}
@@ -0,0 +1,175 @@
+package brc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gzipMembers compresses data as one gzip member per part, with the parts
+// cut at arbitrary byte offsets rather than on line boundaries.
+func gzipMembers(t testing.TB, data []byte, parts, level int) []byte {
+	var buf bytes.Buffer
+	size := len(data)/parts + 1
+
+	for start := 0; start < len(data); start += size {
+		zw, err := gzip.NewWriterLevel(&buf, level)
+		require.NoError(t, err)
+
+		_, err = zw.Write(data[start:min(start+size, len(data))])
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func TestAggregateCompressed(t *testing.T) {
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	// a station name holding a gzip header makes for a false positive
+	// candidate inside an uncompressed (stored) member
+	plain = append(plain, "x\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x00;1.0\n"...)
+
+	var agg Aggregator
+	want, err := agg.AggregateReader(bytes.NewReader(plain))
+	require.NoError(t, err)
+
+	var zstdBuf bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdBuf)
+	require.NoError(t, err)
+	_, err = zw.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tt := []struct {
+		name string
+		data []byte
+	}{
+		{"single.gz", gzipMembers(t, plain, 1, gzip.DefaultCompression)},
+		{"multi.gz", gzipMembers(t, plain, 7, gzip.DefaultCompression)},
+		{"stored.gz", gzipMembers(t, plain, 3, gzip.NoCompression)},
+		{"no_extension", gzipMembers(t, plain, 5, gzip.BestSpeed)},
+		{"data.zst", zstdBuf.Bytes()},
+		{"zstd_no_extension", zstdBuf.Bytes()},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			require.NoError(t, os.WriteFile(path, tc.data, 0o600))
+
+			for _, workers := range []int{1, 2, 8} {
+				agg := Aggregator{Workers: workers, BufferSize: 1 << 10}
+
+				got, err := agg.AggregateFile(path)
+				require.NoError(t, err, workers)
+				assert.Equal(t, want, got, fmt.Sprint(workers))
+
+				got, err = agg.AggregateReader(bytes.NewReader(tc.data))
+				require.NoError(t, err, workers)
+				assert.Equal(t, want, got, fmt.Sprint(workers))
+			}
+		})
+	}
+}
+
+func TestAggregateGzipRejections(t *testing.T) {
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	// malformed lines all over, every other one cut in half by the end of a
+	// member
+	lines := bytes.SplitAfter(plain, []byte("\n"))
+	var cuts []int
+	for i, offset := 0, 0; i < len(lines); i++ {
+		if i%37 == 3 {
+			lines[i] = []byte("malformed line\n")
+			if i%2 == 0 {
+				cuts = append(cuts, offset+5)
+			}
+		}
+		offset += len(lines[i])
+	}
+	plain = bytes.Join(lines, nil)
+	cuts = append(cuts, len(plain))
+
+	var buf bytes.Buffer
+	start := 0
+	for _, end := range cuts {
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(plain[start:end])
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		start = end
+	}
+
+	path := filepath.Join(t.TempDir(), "measurements.gz")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+
+	for _, policy := range []ErrorPolicy{Report, Fail} {
+		agg := Aggregator{Workers: 3, BufferSize: 1 << 10, OnError: policy}
+		want, wantErr := agg.AggregateReader(bytes.NewReader(plain))
+		got, err := agg.AggregateFile(path)
+
+		if policy == Fail {
+			var perr *ParseError
+			require.ErrorAs(t, err, &perr)
+			wantErr.(*ParseError).Path = path
+			assert.Equal(t, wantErr, perr)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.NotEmpty(t, want.Rejections)
+		for _, perr := range want.Rejections {
+			perr.Path = path
+		}
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestAggregateCompressedErrors(t *testing.T) {
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	multi := gzipMembers(t, plain, 3, gzip.DefaultCompression)
+	truncated := multi[:len(multi)-20]
+	trailingGarbage := append(append([]byte{}, multi...), "garbage"...)
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"truncated.gz":        truncated,
+		"trailing_garbage.gz": trailingGarbage,
+		"not_gzip.gz":         plain,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+
+			agg := Aggregator{Workers: 2}
+			_, err := agg.AggregateFile(path)
+
+			var ferr *FileError
+			require.ErrorAs(t, err, &ferr)
+			assert.Equal(t, "decompress", ferr.Op)
+		})
+	}
+}
+
+func TestGzipCandidates(t *testing.T) {
+	data := gzipMembers(t, []byte("Jos;3.9\nBanjul;38.9\n"), 3, gzip.DefaultCompression)
+
+	candidates := gzipCandidates(data)
+	require.Len(t, candidates, 3)
+	assert.Equal(t, 0, candidates[0])
+}
@@ -0,0 +1,304 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Coordinator aggregates files that are too large for one machine with
+// worker processes on several: it cuts the files into byte ranges and hands
+// them out to the workers that connect to it (see Aggregator.Work), one
+// range at a time, and merges their results. The range of a worker whose
+// connection breaks, because it died or its machine did, is handed out again
+// to another one.
+type Coordinator struct {
+	// Aggregator holds the settings the workers parse and aggregate with:
+	// OnError, MaxReported, Percentiles, Decimals, the units, Delimiter,
+	// KeyField and ValueField. How the workers read their ranges is up to
+	// their own Aggregator. Only the number of malformed lines is reported
+	// back, not the lines.
+	Aggregator Aggregator
+
+	// RangeSize is the size of the ranges the files are cut into. Defaults to
+	// 64 MiB. Compressed files are not cut.
+	RangeSize int
+}
+
+const defaultRangeSize = 64 << 20
+
+// workRange is a range of a file for a worker. Like a segment, it nominally
+// covers [lo, hi) and owns the lines that start in it.
+type workRange struct {
+	id     int
+	path   string
+	lo, hi int
+}
+
+// Aggregate aggregates the files at paths with the workers that connect to
+// l, and closes l once every range has been aggregated or ctx is done. The
+// files have to be at the same paths for the workers. A malformed line or a
+// file a worker cannot read fails the whole run, like with AggregateFiles.
+func (c *Coordinator) Aggregate(ctx context.Context, l net.Listener, paths ...string) (*Result, error) {
+	defer l.Close()
+
+	if err := c.Aggregator.Validate(); err != nil {
+		return nil, err
+	}
+
+	ranges, err := c.cut(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		settings:  c.Aggregator.appendSettings(nil),
+		pending:   make(chan workRange, len(ranges)),
+		finished:  make(chan struct{}),
+		completed: make([]bool, len(ranges)),
+		remaining: len(ranges),
+		conns:     make(map[net.Conn]bool),
+		merged: &Snapshot{
+			agg:        newAggregation(),
+			conv:       c.Aggregator.conversion(),
+			histograms: c.Aggregator.Percentiles,
+		},
+	}
+	for _, r := range ranges {
+		j.pending <- r
+	}
+	if len(ranges) == 0 {
+		j.finish(nil)
+	}
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			if !j.track(nc) {
+				nc.Close()
+				return
+			}
+
+			go j.serve(nc)
+		}
+	}()
+
+	select {
+	case <-j.finished:
+	case <-ctx.Done():
+		j.finish(ctx.Err())
+	}
+
+	l.Close()
+	j.closeAll()
+
+	if j.err != nil {
+		return nil, j.err
+	}
+
+	return c.Aggregator.result(j.merged.agg, j.merged.conv)
+}
+
+// cut splits the files at paths into ranges of RangeSize.
+func (c *Coordinator) cut(paths []string) ([]workRange, error) {
+	size := c.RangeSize
+	if size <= 0 {
+		size = defaultRangeSize
+	}
+
+	var ranges []workRange
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fileError("open", path, err)
+		}
+
+		stat, err := f.Stat()
+		head := make([]byte, 4)
+		n := 0
+		if err == nil {
+			n, err = f.ReadAt(head, 0)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		f.Close()
+		if err != nil {
+			return nil, fileError("read", path, err)
+		}
+
+		fileSize := int(stat.Size())
+		step := size
+		if detectCompression(path, head[:n]) != uncompressed {
+			step = fileSize
+		}
+
+		for lo := 0; lo < fileSize; lo += step {
+			ranges = append(ranges, workRange{
+				id: len(ranges), path: path, lo: lo, hi: min(lo+step, fileSize),
+			})
+		}
+	}
+
+	return ranges, nil
+}
+
+// job is the state of Coordinator.Aggregate shared by the connections.
+type job struct {
+	settings []byte
+	pending  chan workRange
+	finished chan struct{}
+
+	mu        sync.Mutex
+	completed []bool
+	remaining int
+	merged    *Snapshot
+	err       error
+	done      bool
+	conns     map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+// track registers a new connection, unless the job is over.
+func (j *job) track(nc net.Conn) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.done {
+		return false
+	}
+
+	j.conns[nc] = true
+	j.wg.Add(1)
+	return true
+}
+
+// finish ends the job, with err unless it is nil.
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.done {
+		return
+	}
+
+	j.done = true
+	j.err = err
+	close(j.finished)
+}
+
+// closeAll waits for the connections to tell their workers there is no
+// more work. Workers that are still busy with a range after a failure, or
+// that never said hello, are given a second.
+func (j *job) closeAll() {
+	j.mu.Lock()
+	for nc := range j.conns {
+		nc.SetDeadline(time.Now().Add(time.Second))
+	}
+	j.mu.Unlock()
+
+	j.wg.Wait()
+}
+
+// serve hands out ranges to the worker connected on nc until there are none
+// left. If the connection breaks, the range it was working on goes back to
+// the queue for another worker.
+func (j *job) serve(nc net.Conn) {
+	defer j.wg.Done()
+	defer nc.Close()
+
+	c := newConn(nc)
+	hello, err := c.expect(msgHello)
+	if err != nil || !bytes.Equal(hello, binary.AppendUvarint([]byte(protocolMagic), protocolVersion)) {
+		return
+	}
+	if err := c.send(msgSettings, j.settings); err != nil {
+		return
+	}
+
+	for {
+		var r workRange
+		select {
+		case r = <-j.pending:
+		case <-j.finished:
+			c.send(msgDone, nil)
+			return
+		}
+
+		payload := binary.AppendUvarint(nil, uint64(r.id))
+		payload = appendString(payload, r.path)
+		payload = binary.AppendUvarint(payload, uint64(r.lo))
+		payload = binary.AppendUvarint(payload, uint64(r.hi))
+
+		err := c.send(msgAssign, payload)
+		var typ byte
+		if err == nil {
+			typ, payload, err = c.receive()
+		}
+		if err != nil {
+			j.pending <- r
+			return
+		}
+
+		if err := j.complete(r, typ, payload); err != nil {
+			j.finish(err)
+			return
+		}
+	}
+}
+
+// complete merges the reply of a worker for r.
+func (j *job) complete(r workRange, typ byte, payload []byte) error {
+	d := snapshotDecoder{data: payload}
+	if id := d.uvarint(); d.err != nil || id != uint64(r.id) {
+		return fmt.Errorf("worker replied for range %d, want %d", id, r.id)
+	}
+
+	switch typ {
+	case msgFailed:
+		err := d.error()
+		if d.err != nil {
+			return fmt.Errorf("failed: %w", d.err)
+		}
+
+		return err
+	case msgResult:
+	default:
+		return fmt.Errorf("unexpected message %d", typ)
+	}
+
+	snap, err := decodeSnapshot(d.data)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.done || j.completed[r.id] {
+		return nil
+	}
+
+	if err := j.merged.Merge(snap); err != nil {
+		return err
+	}
+
+	j.completed[r.id] = true
+	j.remaining--
+	if j.remaining == 0 {
+		j.done = true
+		close(j.finished)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package brc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coordinate runs c over paths with one worker per Aggregator in workers,
+// all on localhost, plus whatever extra connects to the listener.
+func coordinate(t *testing.T, c *Coordinator, workers []Aggregator, extra func(addr string), paths ...string) (*Result, error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	if extra != nil {
+		extra(l.Addr().String())
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w Aggregator) {
+			defer wg.Done()
+
+			nc, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return // the job was done before this worker connected
+			}
+			defer nc.Close()
+
+			w.Work(nc)
+		}(w)
+	}
+	defer wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return c.Aggregate(ctx, l, paths...)
+}
+
+// splitSample writes testdata/sample_data.txt to dir as three files, the
+// middle one gzip-ed.
+func splitSample(t *testing.T, dir string) []string {
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	lines := strings.SplitAfter(string(plain), "\n")
+	parts := [][]byte{
+		[]byte(strings.Join(lines[:150], "")),
+		gzipMembers(t, []byte(strings.Join(lines[150:200], "")), 1, 6),
+		[]byte(strings.Join(lines[200:], "")),
+	}
+
+	var paths []string
+	for i, part := range parts {
+		path := filepath.Join(dir, fmt.Sprintf("part-%d", i))
+		require.NoError(t, os.WriteFile(path, part, 0o600))
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func TestCoordinator(t *testing.T) {
+	paths := splitSample(t, t.TempDir())
+
+	for _, settings := range []Aggregator{
+		{},
+		{Percentiles: true, OutputUnit: Fahrenheit, OnError: Skip},
+	} {
+		want, err := settings.AggregateFiles(paths...)
+		require.NoError(t, err)
+
+		// the workers read with their own settings, and parse with the
+		// coordinator's
+		c := &Coordinator{Aggregator: settings, RangeSize: 500}
+		got, err := coordinate(t, c, []Aggregator{{}, {Workers: 2, Backend: Pread}, {MemoryBudget: 1}}, nil, paths...)
+		require.NoError(t, err)
+		assert.Equal(t, want.Stations, got.Stations)
+		assert.Equal(t, want.Rejected, got.Rejected)
+	}
+}
+
+func TestCoordinatorRetry(t *testing.T) {
+	paths := splitSample(t, t.TempDir())
+
+	var agg Aggregator
+	want, err := agg.AggregateFiles(paths...)
+	require.NoError(t, err)
+
+	// a worker that dies with its first range, and one that dies halfway
+	// through sending its result, before the real worker connects
+	dead := make(chan struct{}, 2)
+	die := func(addr string, after int) {
+		defer func() { dead <- struct{}{} }()
+
+		nc, err := net.Dial("tcp", addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer nc.Close()
+
+		c := newConn(nc)
+		assert.NoError(t, c.send(msgHello, binary.AppendUvarint([]byte(protocolMagic), protocolVersion)))
+		_, err = c.expect(msgSettings)
+		assert.NoError(t, err)
+		_, err = c.expect(msgAssign)
+		assert.NoError(t, err)
+
+		nc.Write([]byte{msgResult, 100}[:after])
+	}
+
+	got, err := coordinate(t, &Coordinator{RangeSize: 500}, nil, func(addr string) {
+		go die(addr, 0)
+		go die(addr, 2)
+		go func() {
+			<-dead
+			<-dead
+			nc, err := net.Dial("tcp", addr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer nc.Close()
+
+			assert.NoError(t, agg.Work(nc))
+		}()
+	}, paths...)
+
+	require.NoError(t, err)
+	assert.Equal(t, want.Stations, got.Stations)
+}
+
+func TestCoordinatorErrors(t *testing.T) {
+	dir := t.TempDir()
+	paths := splitSample(t, dir)
+
+	bad := filepath.Join(dir, "bad")
+	require.NoError(t, os.WriteFile(bad, []byte("Jos;3.9\nAbcdefg\n"), 0o600))
+
+	_, err := coordinate(t, &Coordinator{}, []Aggregator{{}}, nil, append(paths, bad)...)
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, bad, perr.Path)
+	assert.Equal(t, 2, perr.Line)
+
+	_, err = coordinate(t, &Coordinator{}, nil, nil, filepath.Join(dir, "missing"))
+	var ferr *FileError
+	assert.ErrorAs(t, err, &ferr)
+
+	// no workers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = (&Coordinator{}).Aggregate(ctx, l, paths...)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSettings(t *testing.T) {
+	a := Aggregator{
+		OnError:      Report,
+		MaxReported:  10,
+		Percentiles:  true,
+		Decimals:     NoDecimals,
+		InputUnit:    Fahrenheit,
+		OutputUnit:   Kelvin,
+		StationUnits: map[string]Unit{"Jos": Celsius, "Abha": Kelvin},
+		Delimiter:    ',',
+		KeyField:     2,
+		ValueField:   4,
+	}
+
+	w := Aggregator{Workers: 3, Backend: Pread}
+	require.NoError(t, w.applySettings(a.appendSettings(nil)))
+
+	a.Workers, a.Backend = 3, Pread
+	assert.Equal(t, a, w)
+}
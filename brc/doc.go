@@ -0,0 +1,34 @@
+/*
+Package brc aggregates weather station measurements in the format of the
+billion row challenge: one "<station>;<temperature>" record per line, with
+temperatures carrying exactly one fractional digit.
+
+If you want to see the tests and benchmarks that led to this, checkout
+this repo: https://github.com/arjunmahishi/1brcgo
+
+NOTE: this solution used to only work for the 413 stations generated by the
+generate.go script :P. There is a function in hash_test.go (https://github.com/arjunmahishi/1brcgo/blob/a17e34a5543bcdfcedd7da9c1c862d3e1b1212b7/hash_test.go#L76)
+which finds the right "mod" (len(arr) % mod) for a zero collision hash function.
+The table is now open addressed (linear probing) and grows when it fills up,
+so any number of stations works. The initial size still leaves enough room
+for the 413 stations to almost never probe more than once.
+
+Some of the most impactful optimisations in this solution:
+  - Manually splitting text (instead of bytes.Split). Apart from reducing
+    allocations, it also lets you skip a few iterations based on the smallest
+    station name and smallest temperature length
+  - Mmap did not have a significant impact on performance. It was probably
+    the same as reading the file in chunks concurrently. Aggregator.Backend
+    now picks between mmap, pread and io_uring, and BenchmarkBackends
+    compares them with a warm and a cold page cache.
+  - The custom hash function was not as impactful as I thought it would be.
+    Just saved a few hundred milliseconds.
+  - String conversion using unsafe was a lot faster than using string(someByteSlice) because
+    it reuses the already allocated memory for the byte slice.
+  - Avoiding strings.ParseFloat/Atoi saved a lot of time. I was able to parse
+    the temperature as an int directly from the byte slice by transposing the
+    ASCII values of each character to it's integer counterpart.
+  - No locks or waitgroups were used. The fan-in of processed chunks was done
+    using a single channel and a counter.
+*/
+package brc
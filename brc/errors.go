@@ -0,0 +1,65 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// FileError is returned when the input cannot be opened, inspected or read.
+type FileError struct {
+	Op   string // "open", "stat", "read"
+	Path string // empty for readers
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Path == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// MmapError is returned when the input file cannot be memory mapped.
+type MmapError struct {
+	Path string
+	Err  error
+}
+
+func (e *MmapError) Error() string {
+	return "mmap " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *MmapError) Unwrap() error { return e.Err }
+
+// ParseError reports a malformed line. Offset is the byte offset of the
+// start of the line and Line is 1-based.
+type ParseError struct {
+	Path   string // empty for readers
+	Offset int64
+	Line   int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	pos := fmt.Sprintf("line %d (byte offset %d)", e.Line, e.Offset)
+	if e.Path != "" {
+		pos = e.Path + ": " + pos
+	}
+
+	return pos + ": " + e.Reason
+}
+
+// fileError unwraps the *fs.PathError returned by the os package, so the
+// path and operation are not repeated in the message.
+func fileError(op, path string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return &FileError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,80 @@
+package brc
+
+// maxFixed caps the fixed-point values read by parseFixed, so that the sums
+// of close to a billion of them still fit in an int, even once converted to
+// Celsius with an extra decimal. The sums of their squares do not, and take
+// 128 bits (see sumSquares).
+const maxFixed = 999_999_999
+
+// maxDecimals is the most decimals the aggregated values can have (see
+// Aggregator.internalDecimals) with maxFixed still holding ±999.9 degrees.
+const maxDecimals = 6
+
+// parseFixed parses a temperature with any number of decimals, or none, into
+// a fixed-point value with the given number of decimals. Extra decimals are
+// rounded half away from zero, missing ones are zero. There has to be at
+// least one digit before the point, and one after it if there is a point.
+// Values beyond maxFixed are returned as ±(maxFixed+1), for the caller to
+// reject as out of range.
+func parseFixed(s []byte, decimals int) (int, bool) {
+	neg := len(s) > 0 && s[0] == '-'
+	if neg {
+		s = s[1:]
+	}
+
+	v, i := 0, 0
+	for ; i < len(s) && isDigit(s[i]); i++ {
+		v = appendDigit(v, int(s[i]-'0'))
+	}
+
+	if i == 0 {
+		return 0, false
+	}
+
+	frac, roundUp := 0, false
+	if i < len(s) {
+		if s[i] != '.' || i == len(s)-1 {
+			return 0, false
+		}
+
+		for i++; i < len(s); i++ {
+			if !isDigit(s[i]) {
+				return 0, false
+			}
+
+			switch {
+			case frac < decimals:
+				v = appendDigit(v, int(s[i]-'0'))
+				frac++
+			case frac == decimals:
+				roundUp = s[i] >= '5'
+				frac++
+			}
+		}
+	}
+
+	for ; frac < decimals; frac++ {
+		v = appendDigit(v, 0)
+	}
+
+	if roundUp {
+		v++
+	}
+
+	v = min(v, maxFixed+1)
+	if neg {
+		v = -v
+	}
+
+	return v, true
+}
+
+// appendDigit returns v*10+d, or maxFixed+1 once that is beyond maxFixed,
+// without overflowing a 32-bit int.
+func appendDigit(v, d int) int {
+	if v > (maxFixed-d)/10 {
+		return maxFixed + 1
+	}
+
+	return v*10 + d
+}
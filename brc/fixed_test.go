@@ -0,0 +1,154 @@
+package brc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFixed(t *testing.T) {
+	tt := []struct {
+		in       string
+		decimals int
+		want     int
+		ok       bool
+	}{
+		{"23", 1, 230, true},
+		{"-23", 0, -23, true},
+		{"0", 2, 0, true},
+		{"-0", 2, 0, true},
+		{"12.3", 1, 123, true},
+		{"12.3", 3, 12300, true},
+		{"-4.25", 2, -425, true},
+		{"-4.25", 1, -43, true},
+		{"4.24", 1, 42, true},
+		{"4.2499", 1, 42, true},
+		{"105.3", 0, 105, true},
+		{"105.5", 0, 106, true},
+		{"-105.5", 0, -106, true},
+		{"9.99", 1, 100, true},
+		{"007.5", 1, 75, true},
+		{"999999999", 0, 999999999, true},
+		{"999.9", 6, 999900000, true},
+
+		// out of range
+		{"1000000000", 0, maxFixed + 1, true},
+		{"-1000000000", 0, -maxFixed - 1, true},
+		{"100000000", 1, maxFixed + 1, true},
+		{"99999999.95", 1, maxFixed + 1, true},
+		{"99999999999999999999", 0, maxFixed + 1, true},
+		{"1", 20, maxFixed + 1, true},
+
+		{"", 1, 0, false},
+		{"-", 1, 0, false},
+		{".5", 1, 0, false},
+		{"5.", 1, 0, false},
+		{"1.2.3", 1, 0, false},
+		{"1,5", 1, 0, false},
+		{"--1", 1, 0, false},
+		{"1e3", 1, 0, false},
+		{"1000000000x", 0, 0, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			got, ok := parseFixed([]byte(tc.in), tc.decimals)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAggregateDecimals(t *testing.T) {
+	in := "Abc;23\nAbc;-4.25\nAbc;105.3\nJos;0.005\n"
+
+	agg := Aggregator{Workers: 2, Decimals: 2, Percentiles: true}
+	res, err := agg.AggregateReader(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Stations, 2)
+	assert.Equal(t, 2, res.Decimals)
+
+	abc := res.Stations[0]
+	assert.Equal(t, -4.25, abc.Min)
+	assert.Equal(t, 105.3, abc.Max)
+	assert.Equal(t, 3, abc.Count)
+	assert.Equal(t, 23.0, abc.Median)
+	assert.Equal(t, 0.01, res.Stations[1].Max)
+
+	var buf bytes.Buffer
+	require.NoError(t, newTextWriter(OutputOptions{}).WriteResult(&buf, res))
+	assert.Equal(t, "Abc=-4.25/41.35/105.30\nJos=0.01/0.01/0.01\n", buf.String())
+
+	// whole degrees
+	agg = Aggregator{Decimals: NoDecimals}
+	res, err = agg.AggregateReader(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Decimals)
+	assert.Equal(t, []float64{-4, 105}, []float64{res.Stations[0].Min, res.Stations[0].Max})
+
+	buf.Reset()
+	require.NoError(t, newTextWriter(OutputOptions{}).WriteResult(&buf, res))
+	assert.Equal(t, "Abc=-4/41/105\nJos=0/0/0\n", buf.String())
+
+	// the flexible parser validates, failing on malformed lines unless
+	// another policy is given
+	agg = Aggregator{Decimals: 2}
+	_, err = agg.AggregateReader(strings.NewReader("Abc;1.5\nAbc;x\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 2, perr.Line)
+
+	agg.OnError = Skip
+	res, err = agg.AggregateReader(strings.NewReader("Abc;1.5\nAbc;x\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Rejected)
+
+	// the most decimals that still hold ±999.9 degrees
+	agg = Aggregator{Decimals: 6, OnError: Report}
+	res, err = agg.AggregateReader(strings.NewReader("Abc;-999.9\nAbc;12.5\nAbc;1000\n"))
+	require.NoError(t, err)
+	assert.Equal(t, -999.9, res.Stations[0].Min)
+	assert.Equal(t, 12.5, res.Stations[0].Max)
+	require.Len(t, res.Rejections, 1)
+	assert.Equal(t, `temperature out of range "1000"`, res.Rejections[0].Reason)
+}
+
+func TestAggregatorValidateDecimals(t *testing.T) {
+	for _, agg := range []Aggregator{
+		{Decimals: 6},
+		{Decimals: 5, OutputUnit: Fahrenheit},
+		{Decimals: NoDecimals, InputUnit: Kelvin},
+	} {
+		assert.NoError(t, agg.Validate(), "%+v", agg)
+	}
+
+	for _, agg := range []Aggregator{
+		{Decimals: 7},
+		{Decimals: 6, OutputUnit: Fahrenheit},
+		{Decimals: 6, StationUnits: map[string]Unit{"Abc": Kelvin}},
+		{Decimals: 64},
+		{Decimals: -2},
+	} {
+		assert.Error(t, agg.Validate(), "%+v", agg)
+
+		_, err := agg.AggregateReader(strings.NewReader("Abc;0\n"))
+		assert.Error(t, err, "%+v", agg)
+	}
+}
+
+func TestAggregateDecimalsVariance(t *testing.T) {
+	// the squares of these overflow 64 bits after 9 measurements
+	in := strings.Repeat("Abc;999999.000\nAbc;-999999.000\n", 10)
+
+	for _, workers := range []int{1, 4} {
+		agg := Aggregator{Workers: workers, BufferSize: 64, Decimals: 3}
+		res, err := agg.AggregateReader(strings.NewReader(in))
+		require.NoError(t, err)
+		require.Len(t, res.Stations, 1)
+		assert.InEpsilon(t, 999999.0*999999.0, res.Stations[0].Variance, 1e-15)
+		assert.InEpsilon(t, 999999.0, res.Stations[0].StdDev, 1e-15)
+	}
+}
@@ -0,0 +1,312 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FollowOptions configures Aggregator.Follow.
+type FollowOptions struct {
+	// Interval is the time between two results. Defaults to one second.
+	Interval time.Duration
+
+	// Changed only includes the stations that got new measurements since
+	// the previous result, instead of all of them.
+	Changed bool
+}
+
+// Follow aggregates the file at path and keeps following it as it grows,
+// like tail -F: it is watched with inotify (polled every Interval on other
+// systems), and the complete lines appended to it are aggregated as soon as
+// they are written. emit is called with the result so far once the existing
+// contents are aggregated, and then every Interval if there were new
+// measurements, until ctx is done (after a last result) or emit returns an
+// error.
+//
+// If path is replaced (rotated), the rest of the old file is aggregated and
+// the new one is followed from its start; if it is truncated, it is followed
+// from the start again. The results of Follow have no Snapshot, and their
+// Rejections only hold the malformed lines since the previous result.
+func (a *Aggregator) Follow(ctx context.Context, path string, opts FollowOptions, emit func(*Result) error) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
+	f := &follower{a: a, path: path, state: newAggregation(), changed: make(map[string]bool)}
+	if err := f.open(); err != nil {
+		return err
+	}
+	defer func() { f.file.Close() }()
+
+	events, stop, err := watchFile(path)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := f.read(); err != nil {
+		return err
+	}
+	if err := f.emit(opts.Changed, emit); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := f.catchUp(); err != nil {
+				return err
+			}
+			if !f.flush() {
+				return nil
+			}
+
+			return f.emit(opts.Changed, emit)
+		case <-events:
+			if err := f.catchUp(); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := f.catchUp(); err != nil {
+				return err
+			}
+			if !f.flush() {
+				continue
+			}
+
+			if err := f.emit(opts.Changed, emit); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// follower is the state of Follow: the open file, how much of it has been
+// aggregated, and the aggregates so far.
+type follower struct {
+	a      *Aggregator
+	path   string
+	file   *os.File
+	offset int
+
+	state   *aggregation
+	changed map[string]bool
+
+	// small appends are read into buf and parsed into batch, which are kept
+	// across writes and folded into state before every result
+	buf   []byte
+	batch processedBatch
+}
+
+// Appends of up to a segment would only keep one worker busy, so they are
+// parsed on the goroutine of Follow instead.
+const maxFollowAppend = defaultSegmentSize
+
+func (f *follower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fileError("open", f.path, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fileError("stat", f.path, err)
+	}
+
+	head := make([]byte, 4)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return fileError("read", f.path, err)
+	}
+
+	if !stat.Mode().IsRegular() || detectCompression(f.path, head[:n]) != uncompressed {
+		file.Close()
+		return &FileError{Op: "open", Path: f.path, Err: errors.New("only uncompressed regular files can be followed")}
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.offset = file, 0
+
+	return nil
+}
+
+// catchUp aggregates what was appended to the open file, and switches to
+// the file now at path if it was replaced.
+func (f *follower) catchUp() error {
+	if err := f.read(); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // rotated, and the new file is not there yet
+	}
+	if err != nil {
+		return fileError("stat", f.path, err)
+	}
+
+	current, err := f.file.Stat()
+	if err != nil {
+		return fileError("stat", f.path, err)
+	}
+	if os.SameFile(stat, current) {
+		return nil
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	return f.read()
+}
+
+// read aggregates the complete lines past the offset of the open file, from
+// the start if it was truncated.
+func (f *follower) read() error {
+	stat, err := f.file.Stat()
+	if err != nil {
+		return fileError("stat", f.path, err)
+	}
+
+	size := int(stat.Size())
+	if size < f.offset {
+		f.offset = 0
+	}
+
+	if size-f.offset <= maxFollowAppend {
+		return f.parse(size)
+	}
+
+	end, err := lastLineEnd(f.file, f.offset, size)
+	if err != nil {
+		return fileError("read", f.path, err)
+	}
+	if end == f.offset {
+		return nil
+	}
+
+	agg := newAggregation()
+	f.a.aggregateMapped(agg, []mappedFile{{path: f.path, start: f.offset, size: end, file: f.file}})
+	if agg.firstErr != nil {
+		return agg.firstErr
+	}
+
+	f.offset = end
+	for _, station := range agg.stationList {
+		f.changed[station] = true
+	}
+	f.state.merge(agg, 0)
+
+	return nil
+}
+
+// parse aggregates the complete lines between the offset and size into the
+// batch.
+func (f *follower) parse(size int) error {
+	if f.batch.slots == nil {
+		f.batch = f.a.newBatch()
+		f.batch.ownKeys = true // buf is overwritten by the next append
+	}
+
+	n := size - f.offset
+	if cap(f.buf) < n {
+		f.buf = make([]byte, n)
+	}
+	data := f.buf[:n]
+	if _, err := f.file.ReadAt(data, int64(f.offset)); err != nil {
+		return fileError("read", f.path, err)
+	}
+
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	if len(data) == 0 {
+		return nil
+	}
+
+	start := f.offset
+	if start == 0 {
+		start = bomLen(data)
+	}
+
+	rejected, err := f.a.parseChunk(&f.batch, data[start-f.offset:])
+	if err != nil || rejected.count > 0 {
+		perrs := rejected.lines
+		if perr, ok := err.(*ParseError); ok {
+			perrs = append(perrs, perr)
+		}
+
+		baseLine := mappedFile{file: f.file}.linesBefore(f.offset)
+		for _, perr := range perrs {
+			perr.Path = f.path
+			perr.Offset += int64(start)
+			perr.Line += baseLine
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	f.state.add(chunkResult{rejected: rejected})
+	f.offset += len(data)
+
+	return nil
+}
+
+// flush folds the batch into the state, and reports whether any station
+// changed since the previous result.
+func (f *follower) flush() bool {
+	if f.batch.len > 0 {
+		for _, slot := range f.batch.slots {
+			if slot.count > 0 {
+				f.changed[string(slot.key)] = true
+			}
+		}
+
+		f.state.add(chunkResult{batch: f.batch})
+		f.batch.reset()
+	}
+
+	return len(f.changed) > 0
+}
+
+// emit passes the result so far, or only the stations that changed since
+// the previous one, to fn.
+func (f *follower) emit(changed bool, fn func(*Result) error) error {
+	f.flush()
+
+	res, err := f.a.result(f.state, f.a.conversion())
+	if err != nil {
+		return err
+	}
+	res.snapshot = nil
+
+	if changed {
+		stations := res.Stations[:0]
+		for _, s := range res.Stations {
+			if f.changed[s.Name] {
+				stations = append(stations, s)
+			}
+		}
+		res.Stations = stations
+	}
+
+	clear(f.changed)
+	f.state.rejections = nil
+
+	return fn(res)
+}
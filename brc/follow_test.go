@@ -0,0 +1,163 @@
+package brc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\nAbha;2.0\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan *Result)
+	done := make(chan error)
+	go func() {
+		opts := FollowOptions{Interval: 10 * time.Millisecond, Changed: true}
+		done <- (&Aggregator{OnError: Report}).Follow(ctx, path, opts, func(res *Result) error {
+			results <- res
+			return nil
+		})
+	}()
+
+	counts := func(want map[string]int) *Result {
+		t.Helper()
+
+		select {
+		case res := <-results:
+			got := make(map[string]int)
+			for _, s := range res.Stations {
+				got[s.Name] = s.Count
+			}
+			assert.Equal(t, want, got)
+			assert.Nil(t, res.Snapshot())
+
+			return res
+		case <-time.After(5 * time.Second):
+			t.Fatal("no result")
+			return nil
+		}
+	}
+
+	appendTo := func(s string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(s)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	counts(map[string]int{"Jos": 1, "Abha": 1})
+
+	// only complete lines, and only the stations that changed
+	appendTo("Abha;3.0\nJos;")
+	counts(map[string]int{"Abha": 2})
+	appendTo("4.0\nJos;x\n")
+	res := counts(map[string]int{"Jos": 2})
+	require.Len(t, res.Rejections, 1)
+	assert.Equal(t, 5, res.Rejections[0].Line)
+
+	// truncated
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\n"), 0o600))
+	res = counts(map[string]int{"Jos": 3})
+	assert.Empty(t, res.Rejections)
+	assert.Equal(t, 1, res.Rejected)
+
+	// rotated, with a last line written to the old file after it was
+	// renamed
+	old, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(path, path+".1"))
+	_, err = old.WriteString("Jos;2.0\n")
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+	require.NoError(t, os.WriteFile(path, []byte("Abha;1.0\n"), 0o600))
+
+	// the two files can be read in separate results
+	latest := map[string]int{}
+	for len(latest) < 2 {
+		select {
+		case res := <-results:
+			for _, s := range res.Stations {
+				latest[s.Name] = s.Count
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no result")
+		}
+	}
+	assert.Equal(t, map[string]int{"Jos": 4, "Abha": 3}, latest)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestFollowErrors(t *testing.T) {
+	var agg Aggregator
+	emit := func(*Result) error { return nil }
+	dir := t.TempDir()
+
+	var ferr *FileError
+	err := agg.Follow(context.Background(), filepath.Join(dir, "missing"), FollowOptions{}, emit)
+	assert.ErrorAs(t, err, &ferr)
+
+	err = agg.Follow(context.Background(), dir, FollowOptions{}, emit)
+	assert.ErrorAs(t, err, &ferr)
+
+	// a malformed line stops following with the Fail policy
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\nJos\n"), 0o600))
+
+	var perr *ParseError
+	err = agg.Follow(context.Background(), path, FollowOptions{}, emit)
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 2, perr.Line)
+}
+
+func TestFollowAppends(t *testing.T) {
+	// more than maxFollowAppend to start with, aggregated by the workers
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("Abha;2.0\n", maxFollowAppend/9+1)), 0o600))
+
+	f := &follower{a: &Aggregator{}, path: path, state: newAggregation(), changed: make(map[string]bool)}
+	require.NoError(t, f.open())
+	defer f.file.Close()
+	require.NoError(t, f.read())
+
+	w, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	appendLine := func() {
+		_, err := w.WriteString("Jos;1.5\n")
+		require.NoError(t, err)
+		require.NoError(t, f.read())
+	}
+	appendLine()
+
+	// a line at a time is parsed into the same batch, without allocating
+	// batches or starting workers
+	const appends = 100
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < appends; i++ {
+		appendLine()
+	}
+	runtime.ReadMemStats(&after)
+	assert.Less(t, (after.TotalAlloc-before.TotalAlloc)/appends, uint64(1<<10))
+
+	require.True(t, f.flush())
+	res, err := f.a.result(f.state, f.a.conversion())
+	require.NoError(t, err)
+	require.Len(t, res.Stations, 2)
+	assert.Equal(t, maxFollowAppend/9+1, res.Stations[0].Count)
+	assert.Equal(t, appends+1, res.Stations[1].Count)
+}
@@ -1,4 +1,4 @@
-package main
+package brc
 
 import (
 	"bytes"
@@ -190,13 +190,16 @@ func TestHandleChunkNew(t *testing.T) {
 
 	for i, tc := range tt {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			res := handleChunk(tc.in)
+			res, err := handleChunk(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
 
 			// res.iter(func(station []byte, temp *temprature) {
 			// 	log.Println(string(station), temp)
 			// })
 
-			for _, entry := range res {
+			for _, entry := range res.slots {
 				if entry.count == 0 {
 					continue
 				}
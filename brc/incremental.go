@@ -0,0 +1,297 @@
+package brc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Checkpoint records how far AggregateIncremental got through a set of files
+// that keep growing, together with the snapshot of everything aggregated so
+// far.
+type Checkpoint struct {
+	Snapshot *Snapshot
+	Files    []FileOffset
+}
+
+// FileOffset is how much of a file has been aggregated: everything before
+// Offset, which is the end of its last complete line at the time. Device and
+// Inode identify the file, to tell a rotated file from one that was appended
+// to.
+type FileOffset struct {
+	Path          string
+	Device, Inode uint64
+	Offset        int64
+
+	// Reset is set by AggregateIncremental if the file was truncated or
+	// replaced since the previous checkpoint, and was aggregated from the
+	// start. It is not saved.
+	Reset bool
+}
+
+// AggregateIncremental aggregates what was appended to the files at paths
+// since cp was taken, merges it into cp's snapshot and returns the combined
+// result and the checkpoint to pass to the next run. cp is nil for the first
+// run, and is not modified.
+//
+// Only the bytes past a file's offset are read, up to the end of its last
+// complete line; a line that is still being written is left for the next
+// run. A file whose inode changed (it was rotated) or that is now smaller
+// than its offset (it was truncated) is aggregated from the start, keeping
+// what was aggregated of its previous contents. The files have to be
+// uncompressed regular files, and the Aggregator has to use the same
+// Decimals and OutputUnit for every run.
+func (a *Aggregator) AggregateIncremental(cp *Checkpoint, paths ...string) (*Result, *Checkpoint, error) {
+	if err := a.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		agg    = newAggregation()
+		files  []mappedFile
+		next   = &Checkpoint{}
+		seen   = make(map[string]bool, len(paths))
+		offset = make(map[string]FileOffset)
+	)
+
+	if cp != nil {
+		for _, f := range cp.Files {
+			offset[f.Path] = f
+		}
+	}
+
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	for input, path := range paths {
+		prev, ok := offset[path]
+		f, fo, err := openTail(path, prev, ok)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		seen[path] = true
+		next.Files = append(next.Files, fo)
+		if f.size > f.start {
+			f.input = input
+			files = append(files, f)
+		} else {
+			f.file.Close()
+		}
+	}
+
+	// files that were not given this time are kept for the next run
+	if cp != nil {
+		for _, f := range cp.Files {
+			if !seen[f.Path] {
+				next.Files = append(next.Files, f)
+			}
+		}
+	}
+
+	a.aggregateMapped(agg, files)
+	if agg.firstErr != nil {
+		return nil, nil, agg.firstErr
+	}
+
+	// the checkpoint may have been taken with a finer internal precision,
+	// which the merged values are then kept in
+	snap := &Snapshot{agg: agg, conv: a.conversion(), histograms: a.Percentiles}
+	if cp != nil && cp.Snapshot != nil {
+		if err := snap.Merge(cp.Snapshot); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	res, err := a.result(agg, snap.conv)
+	if err != nil {
+		return nil, nil, err
+	}
+	next.Snapshot = res.Snapshot()
+
+	return res, next, nil
+}
+
+// openTail opens the file at path to be aggregated from prev.Offset, or
+// from the start if there is no prev or the file is not the one prev was
+// taken of. The file is always read a segment at a time, so that only the
+// new bytes are mapped or read.
+func openTail(path string, prev FileOffset, ok bool) (mappedFile, FileOffset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return mappedFile{}, FileOffset{}, fileError("open", path, err)
+	}
+
+	f, fo, err := tail(file, path, prev, ok)
+	if err != nil {
+		file.Close()
+	}
+
+	return f, fo, err
+}
+
+func tail(file *os.File, path string, prev FileOffset, ok bool) (mappedFile, FileOffset, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return mappedFile{}, FileOffset{}, fileError("stat", path, err)
+	}
+
+	sys, isUnix := stat.Sys().(*syscall.Stat_t)
+	if !stat.Mode().IsRegular() || !isUnix {
+		return mappedFile{}, FileOffset{}, &FileError{Op: "open", Path: path, Err: errors.New("incremental aggregation needs a regular file")}
+	}
+
+	fo := FileOffset{Path: path, Device: uint64(sys.Dev), Inode: uint64(sys.Ino)}
+	if ok && (fo.Device != prev.Device || fo.Inode != prev.Inode || stat.Size() < prev.Offset) {
+		fo.Reset = true
+	} else if ok {
+		fo.Offset = prev.Offset
+	}
+
+	head := make([]byte, 4)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return mappedFile{}, FileOffset{}, fileError("read", path, err)
+	}
+	if detectCompression(path, head[:n]) != uncompressed {
+		return mappedFile{}, FileOffset{}, &FileError{Op: "open", Path: path, Err: errors.New("incremental aggregation needs an uncompressed file")}
+	}
+
+	end, err := lastLineEnd(file, int(fo.Offset), int(stat.Size()))
+	if err != nil {
+		return mappedFile{}, FileOffset{}, fileError("read", path, err)
+	}
+
+	f := mappedFile{path: path, start: int(fo.Offset), size: end, file: file}
+	fo.Offset = int64(end)
+
+	return f, fo, nil
+}
+
+// lastLineEnd returns the offset just past the last \n between lo and size
+// in f, or lo if there is none. f is read backwards in pieces, so a long
+// partial line at the end does not have to be read in one go.
+func lastLineEnd(f *os.File, lo, size int) (int, error) {
+	buf := make([]byte, 64<<10)
+	for hi := size; hi > lo; {
+		n := min(hi-lo, len(buf))
+		if _, err := f.ReadAt(buf[:n], int64(hi-n)); err != nil {
+			return 0, err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return hi - n + i + 1, nil
+		}
+		hi -= n
+	}
+
+	return lo, nil
+}
+
+// checkpointMagic starts every checkpoint file, followed by
+// checkpointVersion.
+const (
+	checkpointMagic   = "1brcckpt"
+	checkpointVersion = 1
+)
+
+// The encoding, all integers being varints:
+//
+//	magic, version, number of files
+//	per file: path length, path, device, inode, offset
+//	the snapshot, see Snapshot.WriteTo
+//	CRC-32 (IEEE) of everything before it, 4 bytes little endian
+
+// WriteTo writes the checkpoint to w in a versioned binary encoding. It
+// implements io.WriterTo.
+func (cp *Checkpoint) WriteTo(w io.Writer) (int64, error) {
+	buf := []byte(checkpointMagic)
+	buf = binary.AppendUvarint(buf, checkpointVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(cp.Files)))
+	for _, f := range cp.Files {
+		buf = binary.AppendUvarint(buf, uint64(len(f.Path)))
+		buf = append(buf, f.Path...)
+		buf = binary.AppendUvarint(buf, f.Device)
+		buf = binary.AppendUvarint(buf, f.Inode)
+		buf = binary.AppendUvarint(buf, uint64(f.Offset))
+	}
+
+	buf = cp.Snapshot.appendBinary(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// WriteFile writes the checkpoint to the file at path, replacing it
+// atomically like Snapshot.WriteFile.
+func (cp *Checkpoint) WriteFile(path string) error {
+	return writeFileAtomic(path, cp)
+}
+
+// ReadCheckpointFile reads the checkpoint in the file at path. Its errors
+// are those of ReadSnapshotFile.
+func ReadCheckpointFile(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+
+	cp, err := decodeCheckpoint(data)
+	if serr, ok := err.(*SnapshotError); ok {
+		serr.Path = path
+	}
+
+	return cp, err
+}
+
+func decodeCheckpoint(data []byte) (*Checkpoint, error) {
+	if !bytes.HasPrefix(data, []byte(checkpointMagic)) {
+		return nil, &SnapshotError{Reason: "not a checkpoint"}
+	}
+
+	d := snapshotDecoder{data: data[len(checkpointMagic):]}
+	version := d.uvarint()
+	if d.err == nil && version != checkpointVersion {
+		return nil, &SnapshotError{Reason: fmt.Sprintf("unsupported checkpoint version %d", version)}
+	}
+	if d.err != nil || len(d.data) < 4 {
+		return nil, &SnapshotError{Reason: "truncated"}
+	}
+
+	sum := binary.LittleEndian.Uint32(d.data[len(d.data)-4:])
+	if crc32.ChecksumIEEE(data[:len(data)-4]) != sum {
+		return nil, &SnapshotError{Reason: "checksum mismatch, the file is truncated or corrupt"}
+	}
+	d.data = d.data[:len(d.data)-4]
+
+	cp := &Checkpoint{}
+	files := d.int(0, len(d.data))
+	for i := 0; i < files && d.err == nil; i++ {
+		cp.Files = append(cp.Files, FileOffset{
+			Path:   string(d.bytes(d.int(0, len(d.data)))),
+			Device: d.uvarint(),
+			Inode:  d.uvarint(),
+			Offset: int64(d.int(0, -1)),
+		})
+	}
+	if d.err != nil {
+		return nil, &SnapshotError{Reason: d.err.Error()}
+	}
+
+	snap, err := decodeSnapshot(d.data)
+	if err != nil {
+		return nil, err
+	}
+	cp.Snapshot = snap
+
+	return cp, nil
+}
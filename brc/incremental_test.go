@@ -0,0 +1,165 @@
+package brc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateIncremental(t *testing.T) {
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	for _, agg := range []Aggregator{
+		{Percentiles: true},
+		{Backend: Pread, SegmentSize: 100},
+		{MemoryBudget: 1},
+	} {
+		t.Run(agg.Backend.String(), func(t *testing.T) {
+			want, err := agg.AggregateFile("testdata/sample_data.txt")
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "measurements.txt")
+			ckpt := filepath.Join(dir, "checkpoint")
+			require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+			// append the data in pieces that end mid-line, saving and
+			// reading the checkpoint in between
+			var cp *Checkpoint
+			for _, end := range []int{0, 10, 11, 1000, 1001, 5000, len(plain) - 1, len(plain)} {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = f.Write(plain[fileSize(t, path):end])
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+
+				_, next, err := agg.AggregateIncremental(cp, path)
+				require.NoError(t, err)
+				require.Len(t, next.Files, 1)
+				assert.False(t, next.Files[0].Reset)
+				assert.Equal(t, int64(bytes.LastIndexByte(plain[:end], '\n')+1), next.Files[0].Offset)
+
+				require.NoError(t, next.WriteFile(ckpt))
+				cp, err = ReadCheckpointFile(ckpt)
+				require.NoError(t, err)
+			}
+
+			got, err := cp.Snapshot.Result()
+			assert.Equal(t, want.Stations, stations(t, got, err))
+		})
+	}
+}
+
+func fileSize(t *testing.T, path string) int {
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+
+	return int(stat.Size())
+}
+
+func TestAggregateIncrementalReset(t *testing.T) {
+	var agg Aggregator
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	other := filepath.Join(dir, "other.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\nJos;2.0\n"), 0o600))
+	require.NoError(t, os.WriteFile(other, []byte("Abha;5.0\n"), 0o600))
+
+	_, cp, err := agg.AggregateIncremental(nil, path, other)
+	require.NoError(t, err)
+
+	// truncated and written again, without the other file
+	require.NoError(t, os.WriteFile(path, []byte("Jos;3.0\n"), 0o600))
+	res, cp, err := agg.AggregateIncremental(cp, path)
+	require.NoError(t, err)
+	assert.True(t, cp.Files[0].Reset)
+	assert.Equal(t, int64(8), cp.Files[0].Offset)
+	assert.Equal(t, FileOffset{Path: other, Device: cp.Files[1].Device, Inode: cp.Files[1].Inode, Offset: 9}, cp.Files[1])
+	assert.Equal(t, 3, res.Stations[1].Count)
+
+	// rotated: a new file, longer than the old one
+	rotated := filepath.Join(dir, "new.txt")
+	require.NoError(t, os.WriteFile(rotated, []byte("Jos;4.0\nJos;5.0\n"), 0o600))
+	require.NoError(t, os.Rename(rotated, path))
+	res, cp, err = agg.AggregateIncremental(cp, path)
+	require.NoError(t, err)
+	assert.True(t, cp.Files[0].Reset)
+	assert.Equal(t, []Station{
+		{Name: "Abha", Min: 5, Mean: 5, Max: 5, Count: 1, min: 50, max: 50, sum: 50},
+		{Name: "Jos", Min: 1, Mean: 3, Max: 5, Count: 5, min: 10, max: 50, sum: 150, Variance: 2, StdDev: stdDev(2)},
+	}, res.Stations)
+
+	// nothing new
+	_, cp, err = agg.AggregateIncremental(cp, path, other)
+	require.NoError(t, err)
+	assert.False(t, cp.Files[0].Reset)
+	res, err = cp.Snapshot.Result()
+	assert.Equal(t, 5, stations(t, res, err)[1].Count)
+}
+
+func TestAggregateIncrementalErrors(t *testing.T) {
+	var agg Aggregator
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt.gz")
+	require.NoError(t, os.WriteFile(path, gzipMembers(t, []byte("Jos;1.0\n"), 1, 6), 0o600))
+
+	var ferr *FileError
+	_, _, err := agg.AggregateIncremental(nil, path)
+	assert.ErrorAs(t, err, &ferr)
+
+	_, _, err = agg.AggregateIncremental(nil, dir)
+	assert.ErrorAs(t, err, &ferr)
+
+	// a malformed line does not move the checkpoint on
+	path = filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\n"), 0o600))
+	_, cp, err := agg.AggregateIncremental(nil, path)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("Jos\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var perr *ParseError
+	_, _, err = agg.AggregateIncremental(cp, path)
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 2, perr.Line)
+	assert.Equal(t, int64(8), perr.Offset)
+
+	// the settings of the runs have to match
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\nJos;2.0\n"), 0o600))
+	_, _, err = (&Aggregator{OutputUnit: Kelvin}).AggregateIncremental(cp, path)
+	assert.Error(t, err)
+}
+
+func TestReadCheckpointErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;1.0\n"), 0o600))
+
+	_, cp, err := (&Aggregator{}).AggregateIncremental(nil, path)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = cp.WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	for n := 0; n < len(data); n++ {
+		_, err := decodeCheckpoint(data[:n])
+		var serr *SnapshotError
+		require.ErrorAs(t, err, &serr, "truncated to %d bytes", n)
+	}
+
+	_, err = ReadCheckpointFile(path)
+	assert.EqualError(t, err, "snapshot "+path+": not a checkpoint")
+}
@@ -0,0 +1,76 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// layout describes how the station and temperature are laid out on a line.
+type layout struct {
+	delim      byte
+	key, value int  // 0-based fields
+	fields     bool // split at every delimiter rather than the last one
+}
+
+var defaultLayout = layout{delim: ';', key: 0, value: 1}
+
+// split returns the station and temperature fields of line. reason is empty
+// when both are found.
+func (l layout) split(line []byte) (key, value []byte, reason string) {
+	if !l.fields {
+		splitIdx := bytes.LastIndexByte(line, l.delim)
+		if splitIdx < 0 {
+			return nil, nil, "missing " + strconv.QuoteRune(rune(l.delim))
+		}
+
+		return line[:splitIdx], line[splitIdx+1:], ""
+	}
+
+	last := max(l.key, l.value)
+	for i, start := 0, 0; i <= last; i++ {
+		end := bytes.IndexByte(line[start:], l.delim)
+		if end < 0 {
+			if i < last {
+				return nil, nil, fmt.Sprintf("expected at least %d fields, got %d", last+1, i+1)
+			}
+			end = len(line)
+		} else {
+			end += start
+		}
+
+		switch i {
+		case l.key:
+			key = line[start:end]
+		case l.value:
+			value = line[start:end]
+		}
+
+		start = end + 1
+	}
+
+	return key, value, ""
+}
+
+var delimiterNames = map[string]byte{
+	"semicolon": ';',
+	"comma":     ',',
+	"tab":       '\t',
+	"pipe":      '|',
+	`\t`:        '\t',
+}
+
+// ParseDelimiter returns the delimiter for a name ("semicolon", "comma",
+// "tab" or "pipe") or a single character. Newlines and the characters of a
+// temperature cannot be delimiters.
+func ParseDelimiter(name string) (byte, error) {
+	if d, ok := delimiterNames[name]; ok {
+		return d, nil
+	}
+
+	if len(name) != 1 || name[0] == '\n' || name[0] == '-' || name[0] == '.' || isDigit(name[0]) {
+		return 0, fmt.Errorf("invalid delimiter %q", name)
+	}
+
+	return name[0], nil
+}
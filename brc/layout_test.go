@@ -0,0 +1,108 @@
+package brc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayoutSplit(t *testing.T) {
+	wide := layout{delim: ',', key: 1, value: 3, fields: true}
+
+	tt := []struct {
+		name       string
+		layout     layout
+		in         string
+		key, value string
+		reason     string
+	}{
+		{"default", defaultLayout, "a;b;1.0", "a;b", "1.0", ""},
+		{"default missing", defaultLayout, "a,1.0", "", "", "missing ';'"},
+		{"tab", layout{delim: '\t', value: 1}, "a b\t1.0", "a b", "1.0", ""},
+		{"wide", wide, "t0,Jos,s1,1.0,ok", "Jos", "1.0", ""},
+		{"wide last field", wide, "t0,Jos,s1,1.0", "Jos", "1.0", ""},
+		{"wide empty field", wide, "t0,,s1,1.0", "", "1.0", ""},
+		{"wide short", wide, "t0,Jos,s1", "", "", "expected at least 4 fields, got 3"},
+		{"value first", layout{delim: '|', key: 2, value: 0, fields: true}, "1.0|x|Jos", "Jos", "1.0", ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			key, value, reason := tc.layout.split([]byte(tc.in))
+			assert.Equal(t, tc.reason, reason)
+			assert.Equal(t, tc.key, string(key))
+			assert.Equal(t, tc.value, string(value))
+		})
+	}
+}
+
+func TestAggregateLayout(t *testing.T) {
+	in := "time,station,sensor,temp\n" +
+		"0,Jos,a,3.9\n" +
+		"1,Hamilton,b,10.3\n" +
+		"2,Jos,a,-3.9\n"
+
+	agg := Aggregator{Delimiter: ',', KeyField: 2, ValueField: 4, OnError: Skip}
+	res, err := agg.AggregateReader(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Rejected) // the header
+	require.Len(t, res.Stations, 2)
+	assert.Equal(t, "Hamilton", res.Stations[0].Name)
+	assert.Equal(t, 10.3, res.Stations[0].Max)
+	assert.Equal(t, 2, res.Stations[1].Count)
+
+	// a different delimiter alone keeps the two field layout, and validates
+	agg = Aggregator{Delimiter: '|'}
+	res, err = agg.AggregateReader(strings.NewReader("Jos|1.0\nJos|2.0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, res.Stations[0].Mean)
+
+	_, err = agg.AggregateReader(strings.NewReader("Jos|1.0\nJos;2.0\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "missing '|'", perr.Reason)
+
+	// the temperature before the station
+	agg = Aggregator{KeyField: 2, ValueField: 1}
+	res, err = agg.AggregateReader(strings.NewReader("12.5;Jos\n-2.5;Jos\n"))
+	require.NoError(t, err)
+	require.Len(t, res.Stations, 1)
+	assert.Equal(t, "Jos", res.Stations[0].Name)
+	assert.Equal(t, []float64{-2.5, 5, 12.5}, []float64{res.Stations[0].Min, res.Stations[0].Mean, res.Stations[0].Max})
+}
+
+func TestAggregatorValidateLayout(t *testing.T) {
+	for _, agg := range []Aggregator{
+		{KeyField: 2, ValueField: 2},
+		{KeyField: 2},
+		{ValueField: 1},
+		{KeyField: -1, ValueField: 2},
+		{ValueField: -3},
+	} {
+		assert.Error(t, agg.Validate(), "%+v", agg)
+
+		_, err := agg.AggregateReader(strings.NewReader("Jos;1.0\n"))
+		assert.Error(t, err)
+		_, err = agg.AggregateFiles("testdata/does-not-exist.txt")
+		assert.EqualError(t, err, agg.Validate().Error())
+	}
+
+	for _, agg := range []Aggregator{{}, {KeyField: 1}, {ValueField: 2}, {KeyField: 2, ValueField: 1}, {KeyField: 3}} {
+		assert.NoError(t, agg.Validate(), "%+v", agg)
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for name, want := range map[string]byte{"comma": ',', "tab": '\t', `\t`: '\t', "pipe": '|', ";": ';', ":": ':'} {
+		got, err := ParseDelimiter(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	for _, name := range []string{"", "ab", "\n", "-", ".", "5"} {
+		_, err := ParseDelimiter(name)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,97 @@
+package brc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimLineEnd(t *testing.T) {
+	tt := map[string]string{
+		"":            "",
+		"Abc;1.0":     "Abc;1.0",
+		"Abc;1.0\r":   "Abc;1.0",
+		"Abc;1.0 \t":  "Abc;1.0",
+		"Abc;1.0 \r":  "Abc;1.0",
+		"\r":          "",
+		" Abc;1.0\r ": " Abc;1.0",
+	}
+
+	for in, want := range tt {
+		assert.Equal(t, want, string(trimLineEnd([]byte(in))), "%q", in)
+	}
+}
+
+const lfInput = "Jos;3.9\nHamilton;10.3\nJos;-3.9\nA;0.0\nHamilton;9.5\n"
+
+// windowsInput is lfInput with a BOM, \r\n line endings and stray trailing
+// whitespace, and no final line ending.
+var windowsInput = "\xEF\xBB\xBFJos;3.9\r\nHamilton;10.3 \r\nJos;-3.9\r\nA;0.0\t\r\nHamilton;9.5\r"
+
+func TestHandleChunkCRLF(t *testing.T) {
+	want, err := handleChunk([]byte(lfInput))
+	require.NoError(t, err)
+
+	got, err := handleChunk([]byte(strings.TrimPrefix(windowsInput, "\xEF\xBB\xBF")))
+	require.NoError(t, err)
+	assert.Equal(t, batchStats(want), batchStats(got))
+}
+
+// TestAggregateLineEnds tries every chunk size, so that chunks end exactly on
+// each \r and each \n of windowsInput in turn, with both parsers and both the
+// mmap-ed and the streaming pipelines.
+func TestAggregateLineEnds(t *testing.T) {
+	want, err := (&Aggregator{Workers: 1}).AggregateReader(strings.NewReader(lfInput))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "windows.txt")
+	require.NoError(t, os.WriteFile(path, []byte(windowsInput), 0o600))
+
+	for _, policy := range []ErrorPolicy{Unchecked, Fail} {
+		for size := 1; size <= len(windowsInput)+1; size++ {
+			t.Run(fmt.Sprintf("%s/%d", policy, size), func(t *testing.T) {
+				agg := Aggregator{Workers: 3, BufferSize: size, OnError: policy}
+				res, err := agg.AggregateReader(strings.NewReader(windowsInput))
+				require.NoError(t, err)
+				assert.Equal(t, want.Stations, res.Stations)
+
+				agg.SegmentSize = size
+				res, err = agg.AggregateFile(path)
+				require.NoError(t, err)
+				assert.Equal(t, want.Stations, res.Stations)
+			})
+		}
+	}
+}
+
+func TestLineEndErrors(t *testing.T) {
+	in := "\xEF\xBB\xBFJos;3.9\r\nJos;x\r\n"
+
+	agg := Aggregator{OnError: Fail}
+	_, err := agg.AggregateReader(strings.NewReader(in))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, &ParseError{Offset: 12, Line: 2, Reason: `invalid temperature "x"`}, perr)
+
+	// a BOM is only skipped at the start of the input
+	res, err := agg.AggregateReader(strings.NewReader("Jos;3.9\n\xEF\xBB\xBFJos;3.9\n"))
+	require.NoError(t, err)
+	assert.Len(t, res.Stations, 2)
+}
+
+// batchStats flattens the stations of a batch for comparisons.
+func batchStats(pb processedBatch) map[string][4]int {
+	stats := make(map[string][4]int)
+	for _, slot := range pb.slots {
+		if slot.key != nil {
+			stats[string(slot.key)] = [4]int{slot.min, slot.max, slot.sum, slot.count}
+		}
+	}
+
+	return stats
+}
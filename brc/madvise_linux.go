@@ -0,0 +1,15 @@
+package brc
+
+import "syscall"
+
+// adviseSequential tells the kernel a mapping is read front to back, once,
+// so it reads ahead aggressively.
+func adviseSequential(b []byte) {
+	syscall.Madvise(b, syscall.MADV_SEQUENTIAL)
+}
+
+// adviseDontNeed drops the pages of a mapping right away rather than leaving
+// them to the kernel.
+func adviseDontNeed(b []byte) {
+	syscall.Madvise(b, syscall.MADV_DONTNEED)
+}
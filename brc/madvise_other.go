@@ -0,0 +1,7 @@
+//go:build !linux
+
+package brc
+
+func adviseSequential(b []byte) {}
+
+func adviseDontNeed(b []byte) {}
@@ -0,0 +1,272 @@
+package brc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ResultWriter renders a Result in some output format.
+type ResultWriter interface {
+	WriteResult(w io.Writer, res *Result) error
+}
+
+// ResultWriterFunc adapts a function to the ResultWriter interface.
+type ResultWriterFunc func(w io.Writer, res *Result) error
+
+// WriteResult calls f(w, res).
+func (f ResultWriterFunc) WriteResult(w io.Writer, res *Result) error {
+	return f(w, res)
+}
+
+// OutputOptions selects the optional statistics that are written.
+type OutputOptions struct {
+	StdDev      bool // variance and stddev
+	Percentiles bool // median, p90, p95 and p99
+}
+
+var resultWriters = map[string]func(OutputOptions) ResultWriter{
+	"text":       newTextWriter,
+	"json":       newJSONObjectWriter,
+	"json-array": newJSONArrayWriter,
+	"ndjson":     newNDJSONWriter,
+	"csv":        newCSVWriter,
+	"1brc":       newChallengeWriter,
+}
+
+// RegisterFormat makes a new output format available to NewResultWriter. It
+// replaces any format already registered under the same name.
+func RegisterFormat(name string, newWriter func(OutputOptions) ResultWriter) {
+	resultWriters[name] = newWriter
+}
+
+// Formats returns the names of all registered output formats.
+func Formats() []string {
+	names := make([]string, 0, len(resultWriters))
+	for name := range resultWriters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// NewResultWriter returns the writer for the named format.
+func NewResultWriter(format string, opts OutputOptions) (ResultWriter, error) {
+	newWriter, ok := resultWriters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+
+	return newWriter(opts), nil
+}
+
+// column is a single statistic, formatted the same way in every format.
+type column struct {
+	name  string
+	value func(s Station, decimals int) string
+}
+
+func columns(opts OutputOptions) []column {
+	cols := []column{
+		{"min", func(s Station, d int) string { return formatFixed(s.min, d) }},
+		{"mean", func(s Station, d int) string { return formatFixed(s.meanFixed(), d) }},
+		{"max", func(s Station, d int) string { return formatFixed(s.max, d) }},
+		{"count", func(s Station, _ int) string { return strconv.Itoa(s.Count) }},
+	}
+
+	if opts.StdDev {
+		cols = append(cols,
+			column{"variance", func(s Station, d int) string { return strconv.FormatFloat(s.Variance, 'f', d+1, 64) }},
+			column{"stddev", func(s Station, d int) string { return strconv.FormatFloat(s.StdDev, 'f', d+1, 64) }},
+		)
+	}
+
+	if opts.Percentiles {
+		cols = append(cols,
+			column{"median", func(s Station, d int) string { return formatTemp(s.Median, d) }},
+			column{"p90", func(s Station, d int) string { return formatTemp(s.P90, d) }},
+			column{"p95", func(s Station, d int) string { return formatTemp(s.P95, d) }},
+			column{"p99", func(s Station, d int) string { return formatTemp(s.P99, d) }},
+		)
+	}
+
+	return cols
+}
+
+// formatTemp is for the statistics that are exact fixed-point values already
+// (the percentiles), so the float formatting has nothing to round.
+func formatTemp(v float64, decimals int) string {
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// newTextWriter is the original station=min/mean/max output, one station per
+// line, with the optional statistics appended as key=value pairs.
+func newTextWriter(opts OutputOptions) ResultWriter {
+	extra := columns(opts)[4:]
+
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		bw := bufio.NewWriter(w)
+		for _, s := range res.Stations {
+			fmt.Fprintf(
+				bw, "%s=%s/%s/%s", s.Name,
+				formatFixed(s.min, res.Decimals), formatFixed(s.meanFixed(), res.Decimals), formatFixed(s.max, res.Decimals),
+			)
+			for _, col := range extra {
+				fmt.Fprintf(bw, " %s=%s", col.name, col.value(s, res.Decimals))
+			}
+			bw.WriteByte('\n')
+		}
+
+		return bw.Flush()
+	})
+}
+
+// appendJSONStats appends the columns of s as JSON object members. The values
+// are already formatted numbers, so they are written as is.
+func appendJSONStats(buf []byte, cols []column, s Station, decimals int) []byte {
+	for i, col := range cols {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendQuote(buf, col.name)
+		buf = append(buf, ':')
+		buf = append(buf, col.value(s, decimals)...)
+	}
+
+	return buf
+}
+
+func appendJSONName(buf []byte, name string) []byte {
+	quoted, _ := json.Marshal(name) // strings always marshal
+	return append(buf, quoted...)
+}
+
+// newJSONObjectWriter writes {"<station>": {"min": ..., ...}, ...}.
+func newJSONObjectWriter(opts OutputOptions) ResultWriter {
+	cols := columns(opts)
+
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		buf := []byte{'{'}
+		for i, s := range res.Stations {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONName(buf, s.Name)
+			buf = append(buf, ":{"...)
+			buf = appendJSONStats(buf, cols, s, res.Decimals)
+			buf = append(buf, '}')
+		}
+		buf = append(buf, "}\n"...)
+
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+func appendJSONStation(buf []byte, cols []column, s Station, decimals int) []byte {
+	buf = append(buf, `{"station":`...)
+	buf = appendJSONName(buf, s.Name)
+	buf = append(buf, ',')
+	buf = appendJSONStats(buf, cols, s, decimals)
+	return append(buf, '}')
+}
+
+// newJSONArrayWriter writes [{"station": "<station>", "min": ..., ...}, ...].
+func newJSONArrayWriter(opts OutputOptions) ResultWriter {
+	cols := columns(opts)
+
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		buf := []byte{'['}
+		for i, s := range res.Stations {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONStation(buf, cols, s, res.Decimals)
+		}
+		buf = append(buf, "]\n"...)
+
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// newNDJSONWriter writes one {"station": "<station>", "min": ..., ...} object
+// per line.
+func newNDJSONWriter(opts OutputOptions) ResultWriter {
+	cols := columns(opts)
+
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		var buf []byte
+		for _, s := range res.Stations {
+			buf = appendJSONStation(buf, cols, s, res.Decimals)
+			buf = append(buf, '\n')
+		}
+
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// newCSVWriter writes RFC 4180 CSV with a header row. Station names holding
+// commas or quotes are quoted.
+func newCSVWriter(opts OutputOptions) ResultWriter {
+	cols := columns(opts)
+
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = true
+
+		record := make([]string, len(cols)+1)
+		record[0] = "station"
+		for i, col := range cols {
+			record[i+1] = col.name
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+
+		for _, s := range res.Stations {
+			record[0] = s.Name
+			for i, col := range cols {
+				record[i+1] = col.value(s, res.Decimals)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// newChallengeWriter writes the output expected by the original challenge,
+// {Abha=-23.0/18.0/59.2, Abidjan=...}, on a single line, so that it can be
+// diffed against the expected files of the reference Java implementation.
+// The optional statistics are ignored.
+func newChallengeWriter(OutputOptions) ResultWriter {
+	return ResultWriterFunc(func(w io.Writer, res *Result) error {
+		buf := []byte{'{'}
+		for i, s := range res.Stations {
+			if i > 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = append(buf, s.Name...)
+			buf = append(buf, '=')
+			buf = appendFixed(buf, s.min, res.Decimals)
+			buf = append(buf, '/')
+			buf = appendFixed(buf, s.meanFixed(), res.Decimals)
+			buf = append(buf, '/')
+			buf = appendFixed(buf, s.max, res.Decimals)
+		}
+		buf = append(buf, "}\n"...)
+
+		_, err := w.Write(buf)
+		return err
+	})
+}
@@ -0,0 +1,120 @@
+package brc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var outputResult = &Result{Decimals: 1, Stations: []Station{
+	{
+		Name: "Abha", Min: -23, Mean: 18.03, Max: 59.2, Count: 3,
+		Variance: 1.5, StdDev: 1.2247, Median: 18, P90: 50.1, P95: 55, P99: 59.2,
+		min: -230, max: 592, sum: 541,
+	},
+	{Name: `Say "cheese"`, Min: 1, Mean: 1, Max: 1, Count: 1, min: 10, max: 10, sum: 10},
+	// %.1f would print 2.2 for the float mean
+	{Name: "Washington, D.C.", Min: -1.5, Mean: 2.25, Max: 4, Count: 2, min: -15, max: 40, sum: 45},
+}}
+
+func writeResult(t *testing.T, format string, opts OutputOptions) string {
+	rw, err := NewResultWriter(format, opts)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, rw.WriteResult(&buf, outputResult))
+
+	return buf.String()
+}
+
+func TestTextWriter(t *testing.T) {
+	assert.Equal(t,
+		"Abha=-23.0/18.0/59.2\nSay \"cheese\"=1.0/1.0/1.0\nWashington, D.C.=-1.5/2.3/4.0\n",
+		writeResult(t, "text", OutputOptions{}),
+	)
+
+	got := writeResult(t, "text", OutputOptions{StdDev: true, Percentiles: true})
+	assert.Equal(t,
+		"Abha=-23.0/18.0/59.2 variance=1.50 stddev=1.22 median=18.0 p90=50.1 p95=55.0 p99=59.2",
+		strings.Split(got, "\n")[0],
+	)
+}
+
+func TestJSONWriters(t *testing.T) {
+	got := writeResult(t, "json", OutputOptions{})
+	assert.Equal(t, `{"Abha":{"min":-23.0,"mean":18.0,"max":59.2,"count":3},`+
+		`"Say \"cheese\"":{"min":1.0,"mean":1.0,"max":1.0,"count":1},`+
+		`"Washington, D.C.":{"min":-1.5,"mean":2.3,"max":4.0,"count":2}}`+"\n", got)
+
+	var obj map[string]map[string]float64
+	require.NoError(t, json.Unmarshal([]byte(got), &obj))
+	assert.Equal(t, 2.0, obj["Washington, D.C."]["count"])
+
+	got = writeResult(t, "json-array", OutputOptions{Percentiles: true})
+	var arr []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(got), &arr))
+	require.Len(t, arr, 3)
+	assert.Equal(t, `Say "cheese"`, arr[1]["station"])
+	assert.Equal(t, 50.1, arr[0]["p90"])
+
+	got = writeResult(t, "ndjson", OutputOptions{StdDev: true})
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		var station map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &station))
+		assert.Contains(t, station, "stddev")
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	assert.Equal(t, "station,min,mean,max,count\r\n"+
+		"Abha,-23.0,18.0,59.2,3\r\n"+
+		`"Say ""cheese""",1.0,1.0,1.0,1`+"\r\n"+
+		`"Washington, D.C.",-1.5,2.3,4.0,2`+"\r\n",
+		writeResult(t, "csv", OutputOptions{}),
+	)
+}
+
+func TestRegisterFormat(t *testing.T) {
+	_, err := NewResultWriter("names", OutputOptions{})
+	assert.Error(t, err)
+
+	RegisterFormat("names", func(OutputOptions) ResultWriter {
+		return ResultWriterFunc(func(w io.Writer, res *Result) error {
+			for _, s := range res.Stations {
+				io.WriteString(w, s.Name+"\n")
+			}
+			return nil
+		})
+	})
+	defer delete(resultWriters, "names")
+
+	assert.Contains(t, Formats(), "names")
+	assert.Equal(t, "Abha\nSay \"cheese\"\nWashington, D.C.\n", writeResult(t, "names", OutputOptions{}))
+}
+
+func TestChallengeWriter(t *testing.T) {
+	res := &Result{Decimals: 1, Stations: []Station{
+		{Name: "Abha", Count: 3, min: -230, max: 592, sum: 541},   // 18.03
+		{Name: "Abidjan", Count: 2, min: 1, max: 2, sum: 3},       // 0.15 -> 0.2
+		{Name: "Abéché", Count: 2, min: -2, max: -1, sum: -3},     // -0.15 -> -0.1
+		{Name: "Accra", Count: 4, min: -1, max: 0, sum: -1},       // -0.025 -> -0.0 -> 0.0
+		{Name: "Addis Ababa", Count: 2, min: 0, max: 0, sum: 0},   // 0.0
+		{Name: "Adelaide", Count: 2, min: -999, max: 999, sum: 1}, // 0.05 -> 0.1
+	}}
+
+	rw, err := NewResultWriter("1brc", OutputOptions{Percentiles: true})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, rw.WriteResult(&buf, res))
+
+	assert.Equal(t, "{Abha=-23.0/18.0/59.2, Abidjan=0.1/0.2/0.2, Abéché=-0.2/-0.1/-0.1, "+
+		"Accra=-0.1/0.0/0.0, Addis Ababa=0.0/0.0/0.0, Adelaide=-99.9/0.1/99.9}\n", buf.String())
+}
@@ -0,0 +1,79 @@
+package brc
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExpandPaths resolves the inputs given on the command line into a list of
+// files for AggregateFiles. Glob patterns are expanded, directories are walked
+// recursively (skipping dot files) and everything else, including "-" for
+// stdin, is passed through as is. The files found in a directory or matched
+// by a pattern are sorted, and a pattern that matches nothing is an error.
+func ExpandPaths(patterns ...string) ([]string, error) {
+	var paths []string
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+
+		if pattern != "-" && strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fileError("glob", pattern, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fileError("glob", pattern, fs.ErrNotExist)
+			}
+		}
+
+		for _, match := range matches {
+			stat, err := os.Stat(match)
+			if err != nil || !stat.IsDir() {
+				// missing files are reported by AggregateFiles
+				paths = append(paths, match)
+				continue
+			}
+
+			files, err := walkDir(match)
+			if err != nil {
+				return nil, err
+			}
+
+			paths = append(paths, files...)
+		}
+	}
+
+	return paths, nil
+}
+
+func walkDir(root string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fileError("walk", path, err)
+		}
+
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
@@ -0,0 +1,68 @@
+package brc
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"2024/01/01.txt", "2024/01/02.txt", "2024/02/01.txt", "2024/.hidden.txt",
+		".git/config", "other.csv",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+	}
+
+	tt := []struct {
+		name string
+		in   []string
+		out  []string
+	}{
+		{
+			name: "directory",
+			in:   []string{dir + "/2024"},
+			out:  []string{"2024/01/01.txt", "2024/01/02.txt", "2024/02/01.txt"},
+		},
+		{
+			name: "glob",
+			in:   []string{dir + "/2024/*/01.txt"},
+			out:  []string{"2024/01/01.txt", "2024/02/01.txt"},
+		},
+		{
+			name: "glob matching directories",
+			in:   []string{dir + "/2024/0[2]"},
+			out:  []string{"2024/02/01.txt"},
+		},
+		{
+			name: "plain files and stdin are kept in order",
+			in:   []string{dir + "/other.csv", "-", dir + "/missing.txt"},
+			out:  []string{"other.csv", "-", "missing.txt"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExpandPaths(tc.in...)
+			require.NoError(t, err)
+
+			for i, path := range got {
+				if rel, err := filepath.Rel(dir, path); err == nil && path != "-" {
+					got[i] = rel
+				}
+			}
+
+			assert.Equal(t, tc.out, got)
+		})
+	}
+
+	_, err := ExpandPaths(dir + "/*.json")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,220 @@
+package brc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The protocol between a Coordinator and its workers (see Aggregator.Work)
+// is a sequence of messages, each a type byte, the length of the payload as
+// a varint and the payload, made of varints and length-prefixed strings like
+// snapshots:
+//
+//	worker       coordinator
+//	hello    ->               magic, version
+//	         <-  settings     the parse settings of the coordinator
+//	         <-  assign       range id, path, start and end offsets
+//	result   ->               range id, snapshot of the range
+//	failed   ->               range id, error
+//	         <-  done         no more ranges
+//
+// A worker gets one range at a time and answers every assign with a result
+// or failed.
+const (
+	msgHello byte = iota + 1
+	msgSettings
+	msgAssign
+	msgResult
+	msgFailed
+	msgDone
+)
+
+const (
+	protocolMagic   = "1brcwork"
+	protocolVersion = 1
+
+	// maxMessageSize bounds the payloads a peer has to allocate for.
+	maxMessageSize = 1 << 30
+)
+
+// conn frames the messages of the protocol.
+type conn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: bufio.NewWriter(rw)}
+}
+
+func (c *conn) send(typ byte, payload []byte) error {
+	c.w.WriteByte(typ)
+	c.w.Write(binary.AppendUvarint(nil, uint64(len(payload))))
+	c.w.Write(payload)
+
+	return c.w.Flush()
+}
+
+func (c *conn) receive() (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if n > maxMessageSize {
+		return 0, nil, fmt.Errorf("message of %d bytes", n)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+
+	return typ, payload, nil
+}
+
+// expect receives a message of type typ.
+func (c *conn) expect(typ byte) ([]byte, error) {
+	got, payload, err := c.receive()
+	if err == nil && got != typ {
+		err = fmt.Errorf("unexpected message %d, want %d", got, typ)
+	}
+
+	return payload, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func (d *snapshotDecoder) string() string {
+	return string(d.bytes(d.int(0, len(d.data))))
+}
+
+// appendSettings encodes the settings of a that decide how lines are parsed
+// and aggregated, for the workers to use instead of their own.
+func (a *Aggregator) appendSettings(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(a.OnError))
+	buf = binary.AppendUvarint(buf, uint64(max(a.MaxReported, 0)))
+	buf = binary.AppendUvarint(buf, uint64(boolInt(a.Percentiles)))
+	buf = binary.AppendVarint(buf, int64(a.Decimals))
+	buf = binary.AppendUvarint(buf, uint64(a.InputUnit))
+	buf = binary.AppendUvarint(buf, uint64(a.OutputUnit))
+	buf = binary.AppendUvarint(buf, uint64(a.Delimiter))
+	buf = binary.AppendUvarint(buf, uint64(a.KeyField))
+	buf = binary.AppendUvarint(buf, uint64(a.ValueField))
+
+	buf = binary.AppendUvarint(buf, uint64(len(a.StationUnits)))
+	for station, unit := range a.StationUnits {
+		buf = appendString(buf, station)
+		buf = binary.AppendUvarint(buf, uint64(unit))
+	}
+
+	return buf
+}
+
+// applySettings replaces the settings of a encoded by appendSettings.
+func (a *Aggregator) applySettings(payload []byte) error {
+	d := snapshotDecoder{data: payload}
+	a.OnError = ErrorPolicy(d.int(0, int(Report)))
+	a.MaxReported = d.int(0, -1)
+	a.Percentiles = d.int(0, 1) == 1
+	a.Decimals = int(d.varint())
+	a.InputUnit = Unit(d.int(int(Celsius), int(Kelvin)))
+	a.OutputUnit = Unit(d.int(int(Celsius), int(Kelvin)))
+	a.Delimiter = byte(d.int(0, 255))
+	a.KeyField = d.int(0, -1)
+	a.ValueField = d.int(0, -1)
+
+	a.StationUnits = nil
+	for n := d.int(0, len(d.data)); n > 0 && d.err == nil; n-- {
+		if a.StationUnits == nil {
+			a.StationUnits = make(map[string]Unit)
+		}
+		a.StationUnits[d.string()] = Unit(d.int(int(Celsius), int(Kelvin)))
+	}
+
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("trailing data")
+	}
+	if d.err == nil {
+		d.err = a.Validate()
+	}
+
+	return d.err
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// the kinds of errors a worker reports, so that the coordinator returns the
+// same types
+const (
+	errOther = iota
+	errParse
+	errFile
+	errMmap
+)
+
+func appendError(buf []byte, err error) []byte {
+	var (
+		perr *ParseError
+		ferr *FileError
+		merr *MmapError
+	)
+
+	switch {
+	case errors.As(err, &perr):
+		buf = binary.AppendUvarint(buf, errParse)
+		buf = appendString(buf, perr.Path)
+		buf = binary.AppendUvarint(buf, uint64(perr.Offset))
+		buf = binary.AppendUvarint(buf, uint64(perr.Line))
+		return appendString(buf, perr.Reason)
+	case errors.As(err, &ferr):
+		buf = binary.AppendUvarint(buf, errFile)
+		buf = appendString(buf, ferr.Op)
+		buf = appendString(buf, ferr.Path)
+		return appendString(buf, ferr.Err.Error())
+	case errors.As(err, &merr):
+		buf = binary.AppendUvarint(buf, errMmap)
+		buf = appendString(buf, merr.Path)
+		return appendString(buf, merr.Err.Error())
+	}
+
+	buf = binary.AppendUvarint(buf, errOther)
+	return appendString(buf, err.Error())
+}
+
+func (d *snapshotDecoder) error() error {
+	switch d.uvarint() {
+	case errParse:
+		return &ParseError{Path: d.string(), Offset: int64(d.int(0, -1)), Line: d.int(0, -1), Reason: d.string()}
+	case errFile:
+		return &FileError{Op: d.string(), Path: d.string(), Err: errors.New(d.string())}
+	case errMmap:
+		return &MmapError{Path: d.string(), Err: errors.New(d.string())}
+	}
+
+	return errors.New(d.string())
+}
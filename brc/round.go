@@ -0,0 +1,74 @@
+package brc
+
+import "strconv"
+
+// The min, mean and max are printed from the exact fixed-point values rather
+// than from the floats in Station. Formatting (sum/count)/10 with %.1f rounds
+// ties to even on an already inexact binary value, so it disagrees with the
+// challenge on values like 0.25 and can print "-0.0". Instead, the mean is
+// rounded in integer arithmetic, half toward positive infinity like Java's
+// Math.round in the reference implementation, and zero is always printed as
+// "0.0".
+
+// meanFixed is the mean rounded to the fixed-point unit (tenths by default).
+func (s Station) meanFixed() int {
+	return roundHalfUp(s.sum, s.Count*max(s.div, 1))
+}
+
+// roundHalfUp returns sum/count rounded to the nearest integer, with ties
+// going toward positive infinity (Java's Math.round).
+func roundHalfUp(sum, count int) int {
+	if count == 0 {
+		return 0
+	}
+
+	return floorDiv(2*sum+count, 2*count)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+
+	return q
+}
+
+// appendFixed formats a fixed-point value with the given number of decimals.
+// Zero is never negative.
+func appendFixed(buf []byte, v, decimals int) []byte {
+	if v < 0 {
+		buf = append(buf, '-')
+		v = -v
+	}
+
+	if decimals == 0 {
+		return strconv.AppendInt(buf, int64(v), 10)
+	}
+
+	scale := pow10(decimals)
+	buf = strconv.AppendInt(buf, int64(v/scale), 10)
+	buf = append(buf, '.')
+
+	// the fraction, zero padded to decimals digits
+	frac := v % scale
+	for scale /= 10; scale > 0; scale /= 10 {
+		buf = append(buf, byte('0'+frac/scale))
+		frac %= scale
+	}
+
+	return buf
+}
+
+func formatFixed(v, decimals int) string {
+	return string(appendFixed(make([]byte, 0, 16), v, decimals))
+}
+
+func pow10(n int) int {
+	p := 1
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+
+	return p
+}
@@ -0,0 +1,106 @@
+package brc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundHalfUp(t *testing.T) {
+	tt := []struct {
+		sum, count, want int
+	}{
+		{0, 1, 0},
+		{5, 2, 3},   // 2.5 -> 3
+		{-5, 2, -2}, // -2.5 -> -2, toward positive infinity
+		{7, 2, 4},
+		{-7, 2, -3},
+		{1, 3, 0},
+		{2, 3, 1},
+		{-1, 3, 0},
+		{-2, 3, -1},
+		{-1, 2, 0}, // -0.5 -> 0, not -1
+		{0, 0, 0},
+		// far beyond what float64 represents exactly on 64-bit
+		{maxInt>>2 | 1, 2, maxInt>>3 + 1},
+		{-(maxInt>>2 | 1), 2, -(maxInt >> 3)},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%d/%d", tc.sum, tc.count), func(t *testing.T) {
+			assert.Equal(t, tc.want, roundHalfUp(tc.sum, tc.count))
+		})
+	}
+}
+
+func TestFormatFixed(t *testing.T) {
+	tt := []struct {
+		in       int
+		decimals int
+		want     string
+	}{
+		{0, 1, "0.0"},
+		{1, 1, "0.1"},
+		{-1, 1, "-0.1"},
+		{9, 1, "0.9"},
+		{-9, 1, "-0.9"},
+		{10, 1, "1.0"},
+		{-10, 1, "-1.0"},
+		{999, 1, "99.9"},
+		{-999, 1, "-99.9"},
+		{12345, 1, "1234.5"},
+		{0, 0, "0"},
+		{-23, 0, "-23"},
+		{0, 3, "0.000"},
+		{5, 2, "0.05"},
+		{-425, 2, "-4.25"},
+		{10530, 2, "105.30"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.want, func(t *testing.T) {
+			assert.Equal(t, tc.want, formatFixed(tc.in, tc.decimals))
+		})
+	}
+}
+
+// TestMeanRounding runs tricky inputs through the whole pipeline and checks
+// the text output, where the old float formatting went wrong.
+func TestMeanRounding(t *testing.T) {
+	tt := []struct {
+		name  string
+		temps []string
+		want  string
+	}{
+		{"tie rounds up", []string{"0.2", "0.3"}, "0.2/0.3/0.3"},
+		{"negative tie rounds toward +inf", []string{"-0.2", "-0.3"}, "-0.3/-0.2/-0.2"},
+		{"float tie", []string{"1.4", "1.5"}, "1.4/1.5/1.5"},
+		{"negative zero mean", []string{"-0.1", "0.0", "0.0"}, "-0.1/0.0/0.0"},
+		{"negative zero input", []string{"-0.0", "-0.0"}, "0.0/0.0/0.0"},
+		{"cancelling extremes", []string{"-99.9", "99.9"}, "-99.9/0.0/99.9"},
+		{"just below a tie", []string{"0.1", "0.1", "0.2", "0.2", "0.2", "0.2", "0.2"}, "0.1/0.2/0.2"},
+		{"negative just past a tie", []string{"-0.1", "-0.2", "-0.2"}, "-0.2/-0.2/-0.1"},
+		{"repeating decimal", []string{"10.0", "10.0", "10.1"}, "10.0/10.0/10.1"},
+		{"thirds", []string{"-10.0", "-10.1", "-10.1"}, "-10.1/-10.1/-10.0"},
+	}
+
+	rw, err := NewResultWriter("text", OutputOptions{})
+	require.NoError(t, err)
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			in := "X;" + strings.Join(tc.temps, "\nX;") + "\n"
+
+			var agg Aggregator
+			res, err := agg.AggregateReader(strings.NewReader(in))
+			require.NoError(t, err)
+
+			var out strings.Builder
+			require.NoError(t, rw.WriteResult(&out, res))
+			assert.Equal(t, "X="+tc.want+"\n", out.String())
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package brc
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+)
+
+// defaultSegmentSize keeps the segments of a 13 GB input in the thousands,
+// small enough to even out the load of the workers and large enough for the
+// cursor and the newline alignment to be noise.
+const defaultSegmentSize = 4 << 20
+
+// PerWorkerSegments is the Aggregator.SegmentSize that cuts the mmap-ed
+// files into Workers segments in total, one per worker.
+const PerWorkerSegments = -1
+
+// segmenter cuts the mmap-ed files into segments of a fixed size and hands
+// them out to the workers through a shared atomic cursor, so a worker that is
+// done with a segment simply takes the next one. Segment i of a file nominally
+// covers [start+i*size, start+(i+1)*size) and owns the lines that start in it, so the
+// boundaries are moved forward to the next \n without any coordination
+// between the workers.
+type segmenter struct {
+	files []mappedFile
+	size  int
+
+	// firsts[i] is the index of the first segment of files[i], and the last
+	// element the total number of segments
+	firsts []int
+	cursor atomic.Int64
+}
+
+func newSegmenter(files []mappedFile, size int) *segmenter {
+	s := &segmenter{files: files, size: size, firsts: make([]int, len(files)+1)}
+	for i, f := range files {
+		s.firsts[i+1] = s.firsts[i] + (f.size-f.start+size-1)/size
+	}
+
+	return s
+}
+
+// next claims the next segment. It returns false once every segment has been
+// claimed.
+func (s *segmenter) next() (fileChunk, bool) {
+	for {
+		i := int(s.cursor.Add(1) - 1)
+		if i >= s.firsts[len(s.files)] {
+			return fileChunk{}, false
+		}
+
+		fi := sort.SearchInts(s.firsts, i+1) - 1
+		f := s.files[fi]
+		nominal := f.start + (i-s.firsts[fi])*s.size
+		if f.data == nil {
+			// aligned once it is mapped
+			return fileChunk{file: f, offset: nominal, end: min(nominal+s.size, f.size)}, true
+		}
+
+		start := alignSegment(f.data, nominal)
+		end := alignSegment(f.data, min(nominal+s.size, f.size))
+
+		// a line longer than the segment, owned by an earlier one
+		if start >= end {
+			continue
+		}
+
+		return fileChunk{file: f, offset: start, data: f.data[start:end]}, true
+	}
+}
+
+// alignSegment moves a segment boundary to the start of the line that owns
+// data[at]: just past the first \n at or after at-1. A file's first segment
+// starts after its byte order mark, if any.
+func alignSegment(data []byte, at int) int {
+	if at == 0 {
+		return bomLen(data)
+	}
+
+	if at >= len(data) {
+		return len(data)
+	}
+
+	nl := bytes.IndexByte(data[at-1:], '\n')
+	if nl < 0 {
+		return len(data)
+	}
+
+	return at + nl
+}
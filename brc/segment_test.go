@@ -0,0 +1,103 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mapped(input int, data string) mappedFile {
+	return mappedFile{input: input, size: len(data), data: []byte(data)}
+}
+
+func TestSegmenter(t *testing.T) {
+	files := []mappedFile{
+		mapped(0, "Jos;3.9\nHamilton;10.3\nA;0.0\n"),
+		mapped(2, ""),
+		mapped(3, "\xEF\xBB\xBFJos;-3.9\nA very long station name;1.0\nB;2.0"),
+	}
+
+	for size := 1; size <= 60; size++ {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			s := newSegmenter(files, size)
+
+			got := make(map[int][]byte)
+			for c, ok := s.next(); ok; c, ok = s.next() {
+				// segments are claimed in order, and end on a \n or at the
+				// end of the file
+				assert.Equal(t, len(got[c.file.input])+bomLen(c.file.data), c.offset)
+				assert.True(t, c.data[len(c.data)-1] == '\n' || c.offset+len(c.data) == len(c.file.data))
+
+				got[c.file.input] = append(got[c.file.input], c.data...)
+			}
+
+			assert.Equal(t, string(files[0].data), string(got[0]))
+			assert.Empty(t, got[2])
+			assert.Equal(t, string(files[2].data[3:]), string(got[3]))
+		})
+	}
+}
+
+func TestSegmenterConcurrent(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&data, "Station %d;%d.%d\n", i%97, i%100, i%10)
+	}
+
+	s := newSegmenter([]mappedFile{mapped(0, data.String())}, 1000)
+
+	var (
+		mu    sync.Mutex
+		total int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c, ok := s.next(); ok; c, ok = s.next() {
+				mu.Lock()
+				total += len(c.data)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, data.Len(), total)
+}
+
+func TestAggregateSegmentSizes(t *testing.T) {
+	var in strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&in, "Station %d;%d.%d\n", i%31, i%100-50, i%10)
+	}
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte(in.String()), 0o600))
+
+	want, err := (&Aggregator{Workers: 1}).AggregateReader(strings.NewReader(in.String()))
+	require.NoError(t, err)
+
+	for _, size := range []int{PerWorkerSegments, 0, 1, 100, 4096, 1 << 20} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			agg := Aggregator{Workers: 4, SegmentSize: size}
+			res, err := agg.AggregateFiles(path, path)
+			require.NoError(t, err)
+			require.Len(t, res.Stations, len(want.Stations))
+
+			for i, s := range res.Stations {
+				assert.Equal(t, 2*want.Stations[i].Count, s.Count)
+				assert.Equal(t, want.Stations[i].Min, s.Min)
+				assert.Equal(t, want.Stations[i].Max, s.Max)
+			}
+		})
+	}
+}
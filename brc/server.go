@@ -0,0 +1,178 @@
+package brc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves a Result as JSON over HTTP, for dashboards:
+//
+//	GET  /stations         every station, like the json-array format
+//	GET  /stations/{name}  a single station, 404 if there is none
+//	GET  /summary          the number of stations and measurements, the
+//	                       overall min, max and mean, and when it was loaded
+//	POST /reload           loads the result again (e.g. after the input
+//	                       changed) and returns the new summary
+//
+// Errors are returned as {"error": "..."}. A failed reload keeps serving the
+// previous result.
+type Server struct {
+	load     func() (*Result, error)
+	stations ResultWriter
+	cols     []column
+
+	// reloading serializes the reloads, mu guards the result
+	reloading sync.Mutex
+	mu        sync.RWMutex
+	res       *Result
+	loadedAt  time.Time
+}
+
+// NewServer returns a Server for the result of load, e.g. an Aggregator's
+// AggregateFiles or a Snapshot's Result. load is called once right away,
+// and again on every reload. opts selects the optional statistics of the
+// stations.
+func NewServer(load func() (*Result, error), opts OutputOptions) (*Server, error) {
+	s := &Server{load: load, stations: newJSONArrayWriter(opts), cols: columns(opts)}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload calls load again and serves its result from then on.
+func (s *Server) Reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	res, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.res, s.loadedAt = res, time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Server) result() (*Result, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.res, s.loadedAt
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := http.MethodGet
+	if r.URL.Path == "/reload" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSONError(w, http.StatusMethodNotAllowed, "use "+method)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/stations":
+		res, _ := s.result()
+		w.Header().Set("Content-Type", "application/json")
+		s.stations.WriteResult(w, res)
+	case strings.HasPrefix(path, "/stations/"):
+		name := strings.TrimPrefix(path, "/stations/")
+		res, _ := s.result()
+		i := sort.Search(len(res.Stations), func(i int) bool { return res.Stations[i].Name >= name })
+		if i == len(res.Stations) || res.Stations[i].Name != name {
+			writeJSONError(w, http.StatusNotFound, "unknown station "+name)
+			return
+		}
+		writeJSON(w, append(appendJSONStation(nil, s.cols, res.Stations[i], res.Decimals), '\n'))
+	case path == "/summary":
+		s.writeSummary(w)
+	case path == "/reload":
+		if err := s.Reload(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeSummary(w)
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// summary is the body of /summary. The temperatures are already formatted
+// numbers, so they are json.Numbers.
+type summary struct {
+	Stations int         `json:"stations"`
+	Count    int         `json:"count"`
+	Min      *extreme    `json:"min"`
+	Max      *extreme    `json:"max"`
+	Mean     json.Number `json:"mean,omitempty"`
+	Unit     string      `json:"unit"`
+	Rejected int         `json:"rejected"`
+	LoadedAt time.Time   `json:"loaded_at"`
+}
+
+type extreme struct {
+	Station string      `json:"station"`
+	Value   json.Number `json:"value"`
+}
+
+func (s *Server) writeSummary(w http.ResponseWriter) {
+	res, loadedAt := s.result()
+	sum := summary{
+		Stations: len(res.Stations),
+		Unit:     res.Unit.String(),
+		Rejected: res.Rejected,
+		LoadedAt: loadedAt,
+	}
+
+	// all stations as one, for the overall mean
+	var total Station
+	lo, hi := 0, 0
+	for i, station := range res.Stations {
+		if station.min < res.Stations[lo].min {
+			lo = i
+		}
+		if station.max > res.Stations[hi].max {
+			hi = i
+		}
+
+		total.Count += station.Count
+		total.sum += station.sum
+		total.div = station.div
+	}
+
+	sum.Count = total.Count
+	if len(res.Stations) > 0 {
+		sum.Min = &extreme{res.Stations[lo].Name, json.Number(formatFixed(res.Stations[lo].min, res.Decimals))}
+		sum.Max = &extreme{res.Stations[hi].Name, json.Number(formatFixed(res.Stations[hi].max, res.Decimals))}
+		sum.Mean = json.Number(formatFixed(total.meanFixed(), res.Decimals))
+	}
+
+	buf, err := json.Marshal(sum)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, append(buf, '\n'))
+}
+
+func writeJSON(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	body, _ := json.Marshal(map[string]string{"error": msg}) // strings always marshal
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(body, '\n'))
+}
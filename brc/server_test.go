@@ -0,0 +1,91 @@
+package brc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;3.9\nAbha;-10.2\nJos;12.4\nAbha;20.1\n"), 0o600))
+
+	agg := Aggregator{OnError: Skip}
+	s, err := NewServer(func() (*Result, error) { return agg.AggregateFiles(path) }, OutputOptions{})
+	require.NoError(t, err)
+
+	get := func(method, target string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	for _, tt := range []struct {
+		method, target string
+		code           int
+		body           string
+	}{
+		{"GET", "/stations", 200, `[{"station":"Abha","min":-10.2,"mean":5.0,"max":20.1,"count":2},{"station":"Jos","min":3.9,"mean":8.2,"max":12.4,"count":2}]`},
+		{"GET", "/stations/Jos", 200, `{"station":"Jos","min":3.9,"mean":8.2,"max":12.4,"count":2}`},
+		{"GET", "/stations/Nowhere", 404, `{"error":"unknown station Nowhere"}`},
+		{"GET", "/summary", 200, `{"stations":2,"count":4,"min":{"station":"Abha","value":-10.2},"max":{"station":"Abha","value":20.1},"mean":6.6,"unit":"C","rejected":0`},
+		{"POST", "/summary", 405, `{"error":"use GET"}`},
+		{"GET", "/reload", 405, `{"error":"use POST"}`},
+		{"GET", "/", 404, `{"error":"not found"}`},
+	} {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			code, body := get(tt.method, tt.target)
+			assert.Equal(t, tt.code, code)
+			assert.True(t, strings.HasPrefix(body, tt.body), body)
+		})
+	}
+
+	// the file changed, and is only aggregated again on a reload
+	require.NoError(t, os.WriteFile(path, []byte("Jos;3.9\nKampala;25.0\nbad\n"), 0o600))
+	code, _ := get("GET", "/stations/Kampala")
+	assert.Equal(t, 404, code)
+
+	code, body := get("POST", "/reload")
+	assert.Equal(t, 200, code)
+	var sum summary
+	require.NoError(t, json.Unmarshal([]byte(body), &sum))
+	assert.Equal(t, 2, sum.Stations)
+	assert.Equal(t, 1, sum.Rejected)
+	assert.Equal(t, &extreme{"Kampala", "25.0"}, sum.Max)
+
+	_, body = get("GET", "/stations/Kampala")
+	assert.Equal(t, `{"station":"Kampala","min":25.0,"mean":25.0,"max":25.0,"count":1}`+"\n", body)
+}
+
+func TestServerReloadError(t *testing.T) {
+	var fail error
+	s, err := NewServer(func() (*Result, error) {
+		if fail != nil {
+			return nil, fail
+		}
+		return &Result{Stations: []Station{{Name: "Jos", Count: 1, min: 39, max: 39, sum: 39}}}, nil
+	}, OutputOptions{})
+	require.NoError(t, err)
+
+	fail = errors.New("gone")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, `{"error":"gone"}`+"\n", rec.Body.String())
+
+	// the previous result is still served
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations/Jos", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, err = NewServer(func() (*Result, error) { return nil, fail }, OutputOptions{})
+	assert.ErrorIs(t, err, fail)
+}
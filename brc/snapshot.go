@@ -0,0 +1,426 @@
+package brc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Snapshot is the exact state behind a Result: the fixed-point min, max, sum,
+// sum of squares and count of every station, and its histogram if
+// percentiles were kept. Unlike a Result it can be saved (WriteTo,
+// WriteFile), loaded back (ReadSnapshot, ReadSnapshotFile) and merged with the
+// snapshots of other runs, so that e.g. daily files are aggregated once and
+// rolled up weekly without reading them again.
+//
+// Only the number of rejected lines is kept, not the lines themselves.
+type Snapshot struct {
+	agg  *aggregation
+	conv conversion
+
+	// histograms is set if every station has one
+	histograms bool
+}
+
+// snapshotMagic starts every snapshot file, followed by snapshotVersion.
+const (
+	snapshotMagic   = "1brcsnap"
+	snapshotVersion = 2
+)
+
+// SnapshotError is returned for a snapshot file that is truncated, corrupt,
+// or was written by a newer version.
+type SnapshotError struct {
+	Path   string // empty for readers
+	Reason string
+}
+
+func (e *SnapshotError) Error() string {
+	if e.Path == "" {
+		return "snapshot: " + e.Reason
+	}
+
+	return "snapshot " + e.Path + ": " + e.Reason
+}
+
+// Snapshot returns the state r was built from, or nil if r was not built by
+// an Aggregator or a Snapshot.
+func (r *Result) Snapshot() *Snapshot {
+	return r.snapshot
+}
+
+// Percentiles reports whether the snapshot has the histograms needed for
+// Station.Median and the other percentiles.
+func (s *Snapshot) Percentiles() bool {
+	return s.histograms
+}
+
+// Result builds the result of the snapshot, as the Aggregator that took it
+// would have.
+func (s *Snapshot) Result() (*Result, error) {
+	res, err := s.agg.result(s.conv)
+	if err != nil {
+		return nil, err
+	}
+
+	res.snapshot = s
+	return res, nil
+}
+
+// Merge adds the stations of other to s, as if both had been aggregated in
+// one run. Both need to have been taken with the same Decimals and
+// OutputUnit. If only one of them kept percentiles, the merged snapshot has
+// none. other is not modified.
+func (s *Snapshot) Merge(other *Snapshot) error {
+	if s.conv.unit != other.conv.unit || s.conv.to != other.conv.to {
+		return fmt.Errorf(
+			"cannot merge a snapshot of %s with %d decimals into one of %s with %d decimals",
+			other.conv.unit, other.conv.to, s.conv.unit, s.conv.to,
+		)
+	}
+
+	// The values are kept with an extra decimal if the measurements were
+	// converted, so the snapshots of the same output may still differ in
+	// that. The coarser one is brought to the finer precision.
+	from := max(s.conv.from, other.conv.from)
+	mine, err := rescale(s.agg.aggData, from-s.conv.from)
+	if err != nil {
+		return err
+	}
+	theirs, err := rescale(other.agg.aggData, from-other.conv.from)
+	if err != nil {
+		return err
+	}
+	s.agg.aggData, s.conv.from = mine, from
+
+	if s.histograms && !other.histograms {
+		for station, row := range s.agg.aggData {
+			row.hist = nil
+			s.agg.aggData[station] = row
+		}
+		s.histograms = false
+	}
+
+	s.agg.rejected += other.agg.rejected
+	for _, station := range other.agg.stationList {
+		temp := theirs[station]
+		temp.hist = nil
+		if s.histograms {
+			temp.hist = theirs[station].hist.clone()
+		}
+
+		s.agg.addStation(station, temp)
+	}
+
+	return nil
+}
+
+// rescale returns the stations of data with their values multiplied by
+// 10^decimals, leaving data as it is.
+func rescale(data map[string]temprature, decimals int) (map[string]temprature, error) {
+	if decimals == 0 {
+		return data, nil
+	}
+
+	m := pow10(decimals)
+	scaled := make(map[string]temprature, len(data))
+	for station, temp := range data {
+		var ok bool
+		if temp.sumSq, ok = temp.sumSq.mul(uint64(m) * uint64(m)); !ok ||
+			temp.sum > maxInt/m || temp.sum < -maxInt/m ||
+			temp.min < -maxInt/m || temp.max > maxInt/m {
+			return nil, fmt.Errorf("cannot merge the snapshots: the values of %s overflow with %d more decimals", station, decimals)
+		}
+
+		temp.min *= m
+		temp.max *= m
+		temp.sum *= m
+		if temp.hist != nil {
+			temp.hist = temp.hist.scale(m)
+		}
+		scaled[station] = temp
+	}
+
+	return scaled, nil
+}
+
+// The encoding, all integers being varints (zig-zag for the signed ones):
+//
+//	magic, version
+//	internal decimals, output decimals, output unit, flags (1: histograms)
+//	rejected lines, number of stations
+//	per station: name length, name, min, max, sum, sum of squares, count
+//	  and with histograms the histogram, see histogram.appendBinary
+//	CRC-32 (IEEE) of everything before it, 4 bytes little endian
+const snapshotHistograms = 1
+
+// WriteTo writes the snapshot to w in a versioned binary encoding. It
+// implements io.WriterTo.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.appendBinary(nil))
+	return int64(n), err
+}
+
+func (s *Snapshot) appendBinary(buf []byte) []byte {
+	begin := len(buf)
+	buf = append(buf, snapshotMagic...)
+	buf = binary.AppendUvarint(buf, snapshotVersion)
+
+	flags := uint64(0)
+	if s.histograms {
+		flags |= snapshotHistograms
+	}
+	buf = binary.AppendUvarint(buf, uint64(s.conv.from))
+	buf = binary.AppendUvarint(buf, uint64(s.conv.to))
+	buf = binary.AppendUvarint(buf, uint64(s.conv.unit))
+	buf = binary.AppendUvarint(buf, flags)
+	buf = binary.AppendUvarint(buf, uint64(s.agg.rejected))
+
+	sort.Strings(s.agg.stationList)
+	buf = binary.AppendUvarint(buf, uint64(len(s.agg.stationList)))
+	for _, station := range s.agg.stationList {
+		temp := s.agg.aggData[station]
+		buf = binary.AppendUvarint(buf, uint64(len(station)))
+		buf = append(buf, station...)
+		buf = binary.AppendVarint(buf, int64(temp.min))
+		buf = binary.AppendVarint(buf, int64(temp.max))
+		buf = binary.AppendVarint(buf, int64(temp.sum))
+		buf = binary.AppendUvarint(buf, temp.sumSq.hi)
+		buf = binary.AppendUvarint(buf, temp.sumSq.lo)
+		buf = binary.AppendUvarint(buf, uint64(temp.count))
+
+		if s.histograms {
+			buf = temp.hist.appendBinary(buf)
+		}
+	}
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[begin:]))
+}
+
+// WriteFile writes the snapshot to the file at path. It is written to a
+// temporary file in the same directory first and renamed over path, so path
+// always holds a complete snapshot.
+func (s *Snapshot) WriteFile(path string) error {
+	return writeFileAtomic(path, s)
+}
+
+// writeFileAtomic writes w to a temporary file next to path and renames it
+// over path.
+func writeFileAtomic(path string, w io.WriterTo) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fileError("create", path, err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return fileError("create", path, err)
+	}
+
+	bw := bufio.NewWriter(f)
+	if _, err = w.WriteTo(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileError("write", path, err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fileError("write", path, err)
+	}
+
+	return nil
+}
+
+// ReadSnapshot reads a snapshot written by Snapshot.WriteTo.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &FileError{Op: "read", Err: err}
+	}
+
+	return decodeSnapshot(data)
+}
+
+// ReadSnapshotFile reads the snapshot in the file at path.
+func ReadSnapshotFile(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+
+	s, err := decodeSnapshot(data)
+	if serr, ok := err.(*SnapshotError); ok {
+		serr.Path = path
+	}
+
+	return s, err
+}
+
+// IsSnapshotFile reports whether the file at path starts like a snapshot,
+// without reading the rest of it.
+func IsSnapshotFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fileError("open", path, err)
+	}
+	defer f.Close()
+
+	head := make([]byte, len(snapshotMagic))
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fileError("read", path, err)
+	}
+
+	return string(head[:n]) == snapshotMagic, nil
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return nil, &SnapshotError{Reason: "not a snapshot"}
+	}
+
+	d := snapshotDecoder{data: data[len(snapshotMagic):]}
+	version := d.uvarint()
+	if d.err == nil && version != snapshotVersion {
+		return nil, &SnapshotError{Reason: fmt.Sprintf("unsupported version %d", version)}
+	}
+	if d.err != nil || len(d.data) < 4 {
+		return nil, &SnapshotError{Reason: "truncated"}
+	}
+
+	sum := binary.LittleEndian.Uint32(d.data[len(d.data)-4:])
+	if crc32.ChecksumIEEE(data[:len(data)-4]) != sum {
+		return nil, &SnapshotError{Reason: "checksum mismatch, the file is truncated or corrupt"}
+	}
+	d.data = d.data[:len(d.data)-4]
+
+	s := &Snapshot{agg: newAggregation()}
+	s.conv.from = d.int(0, maxDecimals)
+	s.conv.to = d.int(0, maxDecimals)
+	s.conv.unit = Unit(d.int(int(Celsius), int(Kelvin)))
+	s.histograms = d.uvarint()&snapshotHistograms != 0
+	s.agg.rejected = d.int(0, -1)
+
+	stations := d.int(0, len(d.data))
+	for i := 0; i < stations && d.err == nil; i++ {
+		station := string(d.bytes(d.int(0, len(d.data))))
+		temp := temprature{
+			min:   int(d.varint()),
+			max:   int(d.varint()),
+			sum:   int(d.varint()),
+			sumSq: sumSquares{d.uvarint(), d.uvarint()},
+			count: d.int(1, -1),
+		}
+		if s.histograms {
+			temp.hist = d.histogram()
+		}
+
+		if _, dup := s.agg.aggData[station]; dup && d.err == nil {
+			d.err = fmt.Errorf("duplicate station %q", station)
+		}
+		s.agg.aggData[station] = temp
+		s.agg.stationList = append(s.agg.stationList, station)
+	}
+
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("trailing data")
+	}
+	if d.err != nil {
+		return nil, &SnapshotError{Reason: d.err.Error()}
+	}
+
+	return s, nil
+}
+
+// snapshotDecoder reads the varints of a snapshot, remembering the first
+// error so that it only has to be checked once in a while.
+type snapshotDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *snapshotDecoder) fail() {
+	if d.err == nil {
+		d.err = errors.New("truncated or corrupt")
+	}
+	d.data = nil
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return v
+}
+
+// int reads an unsigned varint in lo..hi, hi < 0 meaning no upper bound.
+func (d *snapshotDecoder) int(lo, hi int) int {
+	v := d.uvarint()
+	if v > uint64(maxInt) || int(v) < lo || hi >= 0 && int(v) > hi {
+		d.fail()
+		return lo
+	}
+
+	return int(v)
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+func (d *snapshotDecoder) bytes(n int) []byte {
+	if n > len(d.data) {
+		d.fail()
+		return nil
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *snapshotDecoder) histogram() *histogram {
+	h := &histogram{lo: int(d.varint())}
+	h.counts = make([]uint32, d.int(1, maxHistogramSize))
+
+	i := -1
+	for buckets := d.int(0, len(h.counts)); buckets > 0 && d.err == nil; buckets-- {
+		i += d.int(1, len(h.counts)-1-i)
+		n := d.uvarint()
+		if n == 0 || n > math.MaxUint32 {
+			d.fail()
+		}
+		h.counts[i] = uint32(n)
+	}
+
+	return h
+}
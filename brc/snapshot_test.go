@@ -0,0 +1,233 @@
+package brc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stations drops the snapshot of a result, which only matters to the
+// snapshot tests.
+func stations(t *testing.T, res *Result, err error) []Station {
+	t.Helper()
+	require.NoError(t, err)
+
+	return res.Stations
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, agg := range []Aggregator{
+		{},
+		{Percentiles: true},
+		{Percentiles: true, Decimals: 2, OutputUnit: Fahrenheit},
+	} {
+		want, err := agg.AggregateFile("testdata/sample_data.txt")
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		n, err := want.Snapshot().WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+
+		snap, err := ReadSnapshot(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, agg.Percentiles, snap.Percentiles())
+
+		got, err := snap.Result()
+		assert.Equal(t, want.Stations, stations(t, got, err))
+		assert.Equal(t, want.Decimals, got.Decimals)
+		assert.Equal(t, want.Unit, got.Unit)
+	}
+}
+
+func TestSnapshotMerge(t *testing.T) {
+	agg := Aggregator{Percentiles: true, OnError: Skip}
+
+	plain, err := os.ReadFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+	plain = append(plain, "Abha;x\n"...)
+
+	want, err := agg.AggregateReader(bytes.NewReader(plain))
+	require.NoError(t, err)
+
+	// one run per part, saved and read back
+	lines := strings.SplitAfter(string(plain), "\n")
+	dir := t.TempDir()
+	var merged *Snapshot
+	for i, part := range [][]string{lines[:100], lines[100:101], lines[101:]} {
+		res, err := agg.AggregateReader(strings.NewReader(strings.Join(part, "")))
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, string(rune('a'+i)))
+		require.NoError(t, res.Snapshot().WriteFile(path))
+
+		snap, err := ReadSnapshotFile(path)
+		require.NoError(t, err)
+
+		if merged == nil {
+			merged = snap
+		} else {
+			require.NoError(t, merged.Merge(snap))
+		}
+	}
+
+	got, err := merged.Result()
+	assert.Equal(t, want.Stations, stations(t, got, err))
+	assert.Equal(t, want.Rejected, got.Rejected)
+
+	// merging does not modify the merged snapshot, so it can be merged
+	// again
+	before, err := merged.Result()
+	require.NoError(t, err)
+	twice, err := ReadSnapshotFile(filepath.Join(dir, "a"))
+	require.NoError(t, err)
+	require.NoError(t, twice.Merge(merged))
+	require.NoError(t, twice.Merge(merged))
+	after, err := merged.Result()
+	assert.Equal(t, before.Stations, stations(t, after, err))
+
+	doubled, err := twice.Result()
+	require.NoError(t, err)
+	for i, s := range doubled.Stations {
+		assert.Equal(t, 2*want.Stations[i].Count+countIn(lines[:100], s.Name), s.Count, s.Name)
+	}
+}
+
+func countIn(lines []string, station string) int {
+	n := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, station+";") {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestSnapshotMergeMismatch(t *testing.T) {
+	snapshot := func(agg Aggregator) *Snapshot {
+		res, err := agg.AggregateReader(strings.NewReader("Jos;3.9\nAbha;-1.2\n"))
+		require.NoError(t, err)
+
+		return res.Snapshot()
+	}
+
+	celsius := snapshot(Aggregator{})
+	assert.Error(t, celsius.Merge(snapshot(Aggregator{OutputUnit: Kelvin})))
+	assert.Error(t, celsius.Merge(snapshot(Aggregator{Decimals: 2})))
+
+	// without percentiles on one side, the merged snapshot has none
+	percentiles := snapshot(Aggregator{Percentiles: true})
+	require.NoError(t, percentiles.Merge(snapshot(Aggregator{Percentiles: true})))
+	assert.True(t, percentiles.Percentiles())
+	require.NoError(t, percentiles.Merge(celsius))
+	assert.False(t, percentiles.Percentiles())
+
+	res, err := percentiles.Result()
+	got := stations(t, res, err)
+	assert.Equal(t, 3, got[0].Count)
+	assert.Zero(t, got[0].Median)
+}
+
+func TestSnapshotMergeInputUnits(t *testing.T) {
+	snapshot := func(agg Aggregator, in string) *Snapshot {
+		res, err := agg.AggregateReader(strings.NewReader(in))
+		require.NoError(t, err)
+
+		return res.Snapshot()
+	}
+
+	// the Fahrenheit measurements are kept in hundredths, the Celsius ones
+	// in tenths
+	celsius := "Jos;3.9\nAbha;-1.2\nJos;4.4\n"
+	fahrenheit := "Kampala;50.0\nJos;41.0\n"
+
+	want, err := (&Aggregator{InputUnit: Fahrenheit, Percentiles: true}).AggregateReader(
+		strings.NewReader("Jos;39.02\nAbha;29.84\nJos;39.92\n" + fahrenheit),
+	)
+	require.NoError(t, err)
+
+	c := snapshot(Aggregator{Percentiles: true}, celsius)
+	require.NoError(t, c.Merge(snapshot(Aggregator{InputUnit: Fahrenheit, Percentiles: true}, fahrenheit)))
+	got, err := c.Result()
+	assert.Equal(t, want.Stations, stations(t, got, err))
+
+	f := snapshot(Aggregator{InputUnit: Fahrenheit, Percentiles: true}, fahrenheit)
+	require.NoError(t, f.Merge(snapshot(Aggregator{Percentiles: true}, celsius)))
+	got, err = f.Result()
+	assert.Equal(t, want.Stations, stations(t, got, err))
+	assert.Equal(t, 4.4, got.Stations[1].Median)
+}
+
+func TestReadSnapshotErrors(t *testing.T) {
+	res, err := (&Aggregator{Percentiles: true}).AggregateFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = res.Snapshot().WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	// every truncation and every flipped byte is caught
+	for n := 0; n < len(data); n++ {
+		_, err := ReadSnapshot(bytes.NewReader(data[:n]))
+		var serr *SnapshotError
+		require.ErrorAs(t, err, &serr, "truncated to %d bytes", n)
+	}
+	for i := range data {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0x40
+
+		_, err := ReadSnapshot(bytes.NewReader(corrupt))
+		var serr *SnapshotError
+		require.ErrorAs(t, err, &serr, "byte %d flipped", i)
+	}
+
+	newer := bytes.Clone(data)
+	newer[len(snapshotMagic)] = snapshotVersion + 1
+	_, err = ReadSnapshot(bytes.NewReader(newer))
+	assert.EqualError(t, err, "snapshot: unsupported version 3")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Jos;3.9\n"), 0o600))
+	_, err = ReadSnapshotFile(path)
+	assert.EqualError(t, err, "snapshot "+path+": not a snapshot")
+
+	_, err = ReadSnapshotFile(filepath.Join(dir, "missing"))
+	var ferr *FileError
+	assert.ErrorAs(t, err, &ferr)
+}
+
+func TestIsSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	res, err := (&Aggregator{}).AggregateFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	snapshot := filepath.Join(dir, "snapshot")
+	require.NoError(t, res.Snapshot().WriteFile(snapshot))
+	short := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(short, []byte("Jos;3\n"), 0o600))
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+
+	for path, want := range map[string]bool{
+		snapshot:                   true,
+		short:                      false,
+		empty:                      false,
+		"testdata/sample_data.txt": false,
+	} {
+		got, err := IsSnapshotFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, path)
+	}
+
+	_, err = IsSnapshotFile(filepath.Join(dir, "missing"))
+	var ferr *FileError
+	assert.ErrorAs(t, err, &ferr)
+}
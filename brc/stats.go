@@ -0,0 +1,222 @@
+package brc
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// histogram counts the measurements of a station per fixed-point unit (a
+// tenth of a degree by default): counts[i] is the number of measurements
+// equal to lo+i. It starts out covering every value parseTemp can produce,
+// -99.9..99.9, and grows for the wider ranges and scales allowed by
+// Aggregator.Decimals, which is what makes the percentiles derived from it
+// exact, up to maxHistogramSize buckets.
+type histogram struct {
+	lo     int
+	counts []uint32
+}
+
+const (
+	histogramOffset = 999
+	histogramSize   = 2*histogramOffset + 1
+
+	// maxHistogramSize caps a histogram at 16 MiB. Values beyond are clamped
+	// into the edge buckets, so the percentiles of a station whose
+	// measurements span more than that are approximate.
+	maxHistogramSize = 1 << 22
+)
+
+func newHistogram() *histogram {
+	return &histogram{lo: -histogramOffset, counts: make([]uint32, histogramSize)}
+}
+
+func (h *histogram) hi() int {
+	return h.lo + len(h.counts) - 1
+}
+
+func (h *histogram) add(temp int) {
+	i := temp - h.lo
+	if i < 0 || i >= len(h.counts) {
+		i = h.grow(temp)
+	}
+
+	h.counts[i]++
+}
+
+// grow widens the histogram to cover temp, at least doubling it so that
+// growing one value at a time stays cheap, and returns the index of temp.
+func (h *histogram) grow(temp int) int {
+	pad := len(h.counts) / 2
+	lo, hi := h.lo, h.hi()
+	if temp < lo {
+		lo = temp - pad
+	}
+	if temp > hi {
+		hi = temp + pad
+	}
+
+	if hi-lo+1 > maxHistogramSize {
+		lo = max(lo, h.hi()+1-maxHistogramSize)
+		hi = min(hi, lo+maxHistogramSize-1)
+	}
+
+	if lo != h.lo || hi != h.hi() {
+		counts := make([]uint32, hi-lo+1)
+		copy(counts[h.lo-lo:], h.counts)
+		h.lo, h.counts = lo, counts
+	}
+
+	return min(max(temp-h.lo, 0), len(h.counts)-1)
+}
+
+func (h *histogram) merge(other *histogram) {
+	h.grow(other.lo)
+	h.grow(other.hi())
+
+	for i, n := range other.counts {
+		idx := min(max(other.lo+i-h.lo, 0), len(h.counts)-1)
+		h.counts[idx] += n
+	}
+}
+
+// scale returns the histogram of the measurements multiplied by m, clamping
+// them into maxHistogramSize buckets like add does.
+func (h *histogram) scale(m int) *histogram {
+	first, last := -1, -1
+	for i, n := range h.counts {
+		if n != 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return &histogram{lo: h.lo * m, counts: make([]uint32, 1)}
+	}
+
+	scaled := &histogram{
+		lo:     (h.lo + first) * m,
+		counts: make([]uint32, min((last-first)*m+1, maxHistogramSize)),
+	}
+	for i := first; i <= last; i++ {
+		scaled.counts[min((i-first)*m, len(scaled.counts)-1)] += h.counts[i]
+	}
+
+	return scaled
+}
+
+func (h *histogram) clone() *histogram {
+	return &histogram{lo: h.lo, counts: append([]uint32(nil), h.counts...)}
+}
+
+// appendBinary appends the encoding of the histogram in a snapshot: lo, the
+// number of buckets and of non-zero buckets, and then the gap since the
+// previous non-zero bucket and the count of each of them.
+func (h *histogram) appendBinary(buf []byte) []byte {
+	buckets := 0
+	for _, n := range h.counts {
+		if n != 0 {
+			buckets++
+		}
+	}
+
+	buf = binary.AppendVarint(buf, int64(h.lo))
+	buf = binary.AppendUvarint(buf, uint64(len(h.counts)))
+	buf = binary.AppendUvarint(buf, uint64(buckets))
+
+	prev := -1
+	for i, n := range h.counts {
+		if n != 0 {
+			buf = binary.AppendUvarint(buf, uint64(i-prev))
+			buf = binary.AppendUvarint(buf, uint64(n))
+			prev = i
+		}
+	}
+
+	return buf
+}
+
+// percentile returns the smallest value that at least p percent of the count
+// measurements are less than or equal to (the nearest-rank method), so the
+// result is always one of the measured values.
+func (h *histogram) percentile(p, count int) int {
+	rank := max((p*count+99)/100, 1) // ceil(p/100 * count)
+
+	seen := 0
+	for i, n := range h.counts {
+		seen += int(n)
+		if seen >= rank {
+			return h.lo + i
+		}
+	}
+
+	return h.hi()
+}
+
+// sumSquares is the sum of the squares of a station's fixed-point
+// measurements. With the wider scales allowed by Aggregator.Decimals, those
+// overflow 64 bits long before a billion measurements, so it takes 128.
+type sumSquares struct {
+	hi, lo uint64
+}
+
+func (s *sumSquares) addSquare(temp int) {
+	sign := temp >> (bits.UintSize - 1) // branchless, the signs are unpredictable
+	abs := uint64(temp ^ sign - sign)
+
+	hi, lo := bits.Mul64(abs, abs)
+	s.add(sumSquares{hi, lo})
+}
+
+func (s *sumSquares) add(other sumSquares) {
+	var carry uint64
+	s.lo, carry = bits.Add64(s.lo, other.lo, 0)
+	s.hi += other.hi + carry
+}
+
+// mul returns s*m, and false if that overflows 128 bits.
+func (s sumSquares) mul(m uint64) (sumSquares, bool) {
+	carry, lo := bits.Mul64(s.lo, m)
+	over, hi := bits.Mul64(s.hi, m)
+	hi, c := bits.Add64(hi, carry, 0)
+
+	return sumSquares{hi, lo}, over == 0 && c == 0
+}
+
+func (s sumSquares) big() *big.Int {
+	v := new(big.Int).SetUint64(s.hi)
+	v.Lsh(v, 64)
+	return v.Or(v, new(big.Int).SetUint64(s.lo))
+}
+
+// variance returns the population variance, in degrees², of count
+// fixed-point measurements with the given decimals that add up to sum and
+// whose squares add up to sumSq. It is worked out as
+// (count*sumSq - sum²) / count² with exact integer arithmetic, which avoids
+// the cancellation of the float E[x²] - E[x]² formula. The intermediate
+// products overflow 64 bits for large inputs, hence big.Int; this only runs
+// once per station.
+func variance(sum int, sumSq sumSquares, count, decimals int) float64 {
+	if count == 0 {
+		return 0
+	}
+
+	n := big.NewInt(int64(count))
+	s := big.NewInt(int64(sum))
+
+	num := new(big.Int).Mul(n, sumSq.big())
+	num.Sub(num, s.Mul(s, s))
+
+	denom := n.Mul(n, n)
+	denom.Mul(denom, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(2*decimals)), nil))
+
+	v, _ := new(big.Rat).SetFrac(num, denom).Float64()
+	return v
+}
+
+func stdDev(variance float64) float64 {
+	return math.Sqrt(variance)
+}
@@ -0,0 +1,140 @@
+package brc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	h := newHistogram()
+	for temp := 1; temp <= 100; temp++ {
+		h.add(temp)
+	}
+
+	tt := []struct {
+		p, want int
+	}{
+		{0, 1}, {1, 1}, {50, 50}, {90, 90}, {95, 95}, {99, 99}, {100, 100},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprint(tc.p), func(t *testing.T) {
+			assert.Equal(t, tc.want, h.percentile(tc.p, 100))
+		})
+	}
+
+	// the histogram grows for values out of the default range
+	h = newHistogram()
+	h.add(-5000)
+	h.add(5000)
+	h.add(7)
+	assert.Equal(t, -5000, h.percentile(1, 3))
+	assert.Equal(t, 7, h.percentile(50, 3))
+	assert.Equal(t, 5000, h.percentile(100, 3))
+
+	// up to a limit, beyond which values are clamped into the edge bucket
+	h.add(10 * maxHistogramSize)
+	assert.Equal(t, h.hi(), h.percentile(100, 4))
+	assert.LessOrEqual(t, len(h.counts), maxHistogramSize)
+
+	// merging covers the ranges of both
+	other := newHistogram()
+	other.add(-20000)
+	h = newHistogram()
+	h.add(3)
+	h.merge(other)
+	assert.Equal(t, -20000, h.percentile(50, 2))
+	assert.Equal(t, 3, h.percentile(100, 2))
+}
+
+func TestAggregatePercentiles(t *testing.T) {
+	var in strings.Builder
+	for i := 0; i < 1000; i++ {
+		// -50.0, -49.9, ..., 49.9 for Abc and a constant for Jos
+		fmt.Fprintf(&in, "Abc;%.1f\nJos;-3.9\n", float64(i-500)/10)
+	}
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			agg := Aggregator{Workers: workers, Percentiles: true, BufferSize: 1 << 10}
+
+			res, err := agg.AggregateReader(strings.NewReader(in.String()))
+			require.NoError(t, err)
+			require.Len(t, res.Stations, 2)
+
+			abc := res.Stations[0]
+			assert.Equal(t, 1000, abc.Count)
+			assert.Equal(t, -0.1, abc.Median)
+			assert.Equal(t, 39.9, abc.P90)
+			assert.Equal(t, 44.9, abc.P95)
+			assert.Equal(t, 48.9, abc.P99)
+
+			jos := res.Stations[1]
+			assert.Equal(t, []float64{-3.9, -3.9, -3.9, -3.9},
+				[]float64{jos.Median, jos.P90, jos.P95, jos.P99})
+		})
+	}
+
+	// the mmap-ed path merges the histograms of every worker
+	agg := Aggregator{Workers: 4, Percentiles: true}
+	res, err := agg.AggregateFiles("testdata/sample_data.txt", "testdata/sample_data.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Stations[0].Count)
+	assert.Equal(t, res.Stations[0].Min, res.Stations[0].P99)
+}
+
+func TestVariance(t *testing.T) {
+	tt := []struct {
+		name           string
+		temps          []int
+		variance, sdev float64
+	}{
+		{"single", []int{123}, 0, 0},
+		{"symmetric", []int{-10, 10}, 1, 1},
+		{"textbook", []int{20, 40, 40, 40, 50, 50, 70, 90}, 4, 2},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				sum   int
+				sumSq sumSquares
+			)
+			for _, temp := range tc.temps {
+				sum += temp
+				sumSq.addSquare(temp)
+			}
+
+			v := variance(sum, sumSq, len(tc.temps), 1)
+			assert.InDelta(t, tc.variance, v, 1e-12)
+			assert.InDelta(t, tc.sdev, stdDev(v), 1e-12)
+		})
+	}
+
+	// a billion measurements of 99.9 and -99.9 overflow count*sumSq in 64
+	// bits, but the variance is exact
+	const n = 500_000_000
+	assert.Equal(t, 99.9*99.9, variance(0, sumSquares{lo: 2 * n * 999 * 999}, 2*n, 1))
+
+	// a large constant offset, where E[x²] - E[x]² in floats cancels out
+	m := min(n, maxInt/999)
+	assert.Equal(t, 0.0, variance(m*999, sumSquares{lo: uint64(m) * 999 * 999}, m, 1))
+
+	// the squares of the widest values overflow 64 bits after a few of them
+	var sumSq sumSquares
+	for i := 0; i < 10; i++ {
+		sumSq.addSquare(maxFixed)
+		sumSq.addSquare(-maxFixed)
+	}
+	assert.InEpsilon(t, 999999.999*999999.999, variance(0, sumSq, 20, 3), 1e-15)
+
+	// so many decimals that their scale² overflows 64 bits
+	sumSq = sumSquares{}
+	sumSq.addSquare(500_000_000)
+	sumSq.addSquare(100_000_000)
+	assert.InDelta(t, 0.0004, variance(600_000_000, sumSq, 2, 10), 1e-18)
+}
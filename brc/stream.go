@@ -0,0 +1,196 @@
+package brc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+const defaultBufferSize = 4 << 20
+
+type streamChunk struct {
+	seq    int
+	offset int64
+	data   []byte
+}
+
+// aggregateStream is the fallback for inputs that cannot be mmap-ed. The
+// calling goroutine reads r into buffers of BufferSize, cuts each one after
+// its last \n and hands it to a pool of handleChunk workers. The partial line
+// at the end of a buffer is carried over to the start of the next one. A
+// fresh buffer is used for every chunk since the batches point into them.
+func (a *Aggregator) aggregateStream(r io.Reader, path string) (*aggregation, error) {
+	var (
+		workers = a.workers()
+		chunks  = make(chan streamChunk, workers)
+		results = make(chan chunkResult, workers)
+		wg      sync.WaitGroup
+	)
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				results <- a.handleStreamChunk(c)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		readErr <- a.readChunks(r, chunks)
+	}()
+
+	var (
+		agg    = newAggregation()
+		lines  []int
+		errSeq int
+
+		// rejected lines, by chunk, that need their line numbers fixed
+		rejected = map[int][]*ParseError{}
+	)
+
+	for res := range results {
+		for len(lines) <= res.seq {
+			lines = append(lines, 0)
+		}
+		lines[res.seq] = res.lines
+
+		if len(res.rejected.lines) > 0 {
+			rejected[res.seq] = res.rejected.lines
+		}
+
+		agg.add(res)
+		if res.err != nil && agg.firstErr == res.err {
+			errSeq = res.seq
+		}
+	}
+
+	if err := <-readErr; err != nil {
+		var derr decompressError
+		if errors.As(err, &derr) {
+			return nil, fileError("decompress", path, derr.err)
+		}
+
+		return nil, fileError("read", path, err)
+	}
+
+	// the workers only know the line number within their own chunk
+	baseLines := make([]int, len(lines))
+	for seq := 1; seq < len(lines); seq++ {
+		baseLines[seq] = baseLines[seq-1] + lines[seq-1]
+	}
+
+	if perr, ok := agg.firstErr.(*ParseError); ok {
+		perr.Path = path
+		perr.Line += baseLines[errSeq]
+	}
+
+	for seq, perrs := range rejected {
+		for _, perr := range perrs {
+			perr.Path = path
+			perr.Line += baseLines[seq]
+		}
+	}
+
+	return agg, nil
+}
+
+func (a *Aggregator) readChunks(r io.Reader, chunks chan<- streamChunk) error {
+	size := a.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+
+	var (
+		buf    = make([]byte, size)
+		filled int
+		offset int64
+		seq    int
+	)
+
+	for {
+		n, err := fill(r, buf[filled:])
+		filled += n
+
+		if err == io.EOF {
+			if filled > 0 {
+				chunks <- streamChunk{seq: seq, offset: offset, data: buf[:filled]}
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		last := bytes.LastIndexByte(buf, '\n')
+		if last < 0 {
+			// a single line longer than the buffer
+			buf = append(buf, make([]byte, len(buf))...)
+			continue
+		}
+
+		chunks <- streamChunk{seq: seq, offset: offset, data: buf[:last+1]}
+		seq++
+		offset += int64(last + 1)
+
+		next := make([]byte, max(size, filled-last-1))
+		filled = copy(next, buf[last+1:])
+		buf = next
+	}
+}
+
+func (a *Aggregator) handleStreamChunk(c streamChunk) chunkResult {
+	offset := c.offset
+	data := c.data
+	if offset == 0 {
+		skip := bomLen(data)
+		data = data[skip:]
+		offset += int64(skip)
+	}
+
+	batch := a.newBatch()
+	rejected, err := a.parseChunk(&batch, data)
+
+	perrs := rejected.lines
+	if perr, ok := err.(*ParseError); ok {
+		perrs = append(perrs, perr)
+	}
+	for _, perr := range perrs {
+		perr.Offset += offset
+	}
+
+	return chunkResult{
+		batch:    batch,
+		err:      err,
+		rejected: rejected,
+		seq:      c.seq,
+		lines:    bytes.Count(c.data, []byte{'\n'}),
+	}
+}
+
+// fill reads into buf until it is full or r fails. Unlike io.ReadFull, it
+// keeps an io.ErrUnexpectedEOF coming from r (e.g. a truncated archive) apart
+// from simply reaching the end of r.
+func fill(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
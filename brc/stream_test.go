@@ -0,0 +1,93 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateStream(t *testing.T) {
+	var agg Aggregator
+	want, err := agg.AggregateFile("testdata/sample_data.txt")
+	require.NoError(t, err)
+
+	// buffer sizes smaller than a line force the buffer to grow
+	for _, size := range []int{3, 16, 64, 1 << 10, 1 << 20} {
+		for _, workers := range []int{1, 3} {
+			t.Run(fmt.Sprint(size, "/", workers), func(t *testing.T) {
+				file, err := os.Open("testdata/sample_data.txt")
+				require.NoError(t, err)
+				defer file.Close()
+
+				agg := Aggregator{Workers: workers, BufferSize: size}
+
+				// OneByteReader makes sure short reads are handled
+				got, err := agg.AggregateReader(iotest.OneByteReader(file))
+				require.NoError(t, err)
+				assert.Equal(t, want, got)
+			})
+		}
+	}
+}
+
+func TestAggregateStreamErrors(t *testing.T) {
+	in := strings.Repeat("Jos;3.9\n", 10) + "Abcdefg\n" + strings.Repeat("Jos;3.9\n", 10)
+
+	for _, size := range []int{8, 20, 1 << 10} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			agg := Aggregator{Workers: 2, BufferSize: size}
+
+			_, err := agg.AggregateReader(strings.NewReader(in))
+
+			var perr *ParseError
+			require.ErrorAs(t, err, &perr)
+			assert.Equal(t, int64(80), perr.Offset)
+			assert.Equal(t, 11, perr.Line)
+			assert.Equal(t, "missing ';'", perr.Reason)
+		})
+	}
+
+	readErr := errors.New("boom")
+	var agg Aggregator
+	_, err := agg.AggregateReader(io.MultiReader(
+		strings.NewReader("Jos;3.9\n"), iotest.ErrReader(readErr),
+	))
+
+	var ferr *FileError
+	require.ErrorAs(t, err, &ferr)
+	assert.Equal(t, "read", ferr.Op)
+	assert.ErrorIs(t, err, readErr)
+}
+
+func TestAggregateFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "measurements")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o600))
+
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+
+		io.WriteString(w, "Banjul;38.9\nJos;3.9\nBanjul;-38.9\n")
+	}()
+
+	var agg Aggregator
+	res, err := agg.AggregateFile(fifo)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Station{
+		{Name: "Banjul", Min: -38.9, Mean: 0, Max: 38.9, Count: 2, Variance: 1513.21, StdDev: 38.9, min: -389, max: 389},
+		{Name: "Jos", Min: 3.9, Mean: 3.9, Max: 3.9, Count: 1, min: 39, max: 39, sum: 39},
+	}, res.Stations)
+}
@@ -0,0 +1,76 @@
+package brc
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SWAR (SIMD within a register) helpers, looking at eight bytes of the input
+// at a time. Words are loaded little-endian, so the first byte of the input is
+// the lowest byte of the word.
+
+const (
+	swarOnes  = 0x0101010101010101
+	swarHighs = 0x8080808080808080
+
+	semicolons = ';' * swarOnes
+	newlines   = '\n' * swarOnes
+)
+
+// loadWord loads the first eight bytes of b, padded with zeros.
+func loadWord(b []byte) uint64 {
+	if len(b) >= 8 {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	var buf [8]byte
+	copy(buf[:], b)
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// swarMatch sets the high bit of the bytes of word that are equal to the
+// byte repeated in pattern. Bytes above a match can be flagged falsely (the
+// subtraction borrows), but the lowest flagged byte is always a match.
+func swarMatch(word, pattern uint64) uint64 {
+	x := word ^ pattern
+	return (x - swarOnes) &^ x & swarHighs
+}
+
+// swarIndex is the index of the lowest byte flagged by swarMatch.
+func swarIndex(match uint64) int {
+	return bits.TrailingZeros64(match) >> 3
+}
+
+// swarTemp decodes the temperature at the start of word, of the form
+// [-]d.d or [-]dd.d, without branching on its digits. It returns the value in
+// tenths and its length in bytes; ok is false if word does not start with a
+// temperature of that form, in which case temp is garbage.
+//
+// The '.' is the only byte of a temperature apart from the '-' that has bit 4
+// clear (the digits are 0x30-0x39), which locates it among bytes 1 to 3.
+// Shifting the word by its position lines the digits up, and a single
+// multiplication by 100, 10 and 1 at the right offsets adds them up.
+func swarTemp(word uint64) (temp, n int, ok bool) {
+	// with no '.', pretend it is at byte 3 so the shifts stay in range
+	dot := bits.TrailingZeros64(^word&0x10101000 | 0x10000000)
+	signed := int64(^word<<59) >> 63 // -1 if the first byte is '-', else 0
+	digits := (word &^ uint64(signed&0xFF) << (28 - dot)) & 0x0F000F0F00
+	abs := int64((digits * 0x640a0001 >> 32) & 0x3FF)
+	temp = int((abs ^ signed) - signed)
+
+	dotIdx := dot >> 3
+	n = dotIdx + 2
+
+	// every byte but the '-' and the '.' has to be a digit: (b^0x30) <= 9
+	t := word ^ 0x3030303030303030
+	bad := ((t + 0x7676767676767676) | t) & swarHighs & (1<<(8*n) - 1)
+	bad &^= 0x80<<(8*dotIdx) | uint64(signed)&0x80
+
+	intDigits := dotIdx + int(signed)
+	ok = bad == 0 &&
+		byte(word>>(8*dotIdx)) == '.' &&
+		(signed == 0 || byte(word) == '-') &&
+		intDigits >= 1 && intDigits <= 2
+
+	return temp, n, ok
+}
@@ -0,0 +1,98 @@
+package brc
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarTemp(t *testing.T) {
+	// every valid temperature, followed by the rest of a line
+	for v := -999; v <= 999; v++ {
+		s := fmt.Sprintf("%.1f", float64(v)/10)
+		if v > -10 && v < 0 {
+			s = "-" + s[strings.IndexByte(s, '-')+1:]
+		}
+
+		for _, rest := range []string{"", "\n", "\nAbc;1", "\r\n"} {
+			temp, n, ok := swarTemp(loadWord([]byte(s + rest)))
+			require.True(t, ok, s)
+			assert.Equal(t, v, temp, s)
+			assert.Equal(t, len(s), n, s)
+		}
+	}
+
+	for _, s := range []string{
+		"", "-", "1", "1.", ".1", "-.1", "1,0", "1a.0", "a1.0", "--1.0", "123.4",
+		"-123.4", "+1.0", " 1.0", "1.a", "1\n.0", "\n", "Abc;1.0",
+	} {
+		_, _, ok := swarTemp(loadWord([]byte(s)))
+		assert.False(t, ok, "%q", s)
+	}
+}
+
+// TestSwarTempRandom checks that swarTemp only accepts what the validating
+// parser accepts, and agrees with it.
+func TestSwarTempRandom(t *testing.T) {
+	alphabet := []byte("0123456789.-;\n\r ab")
+	rng := rand.New(rand.NewSource(1))
+
+	word := make([]byte, 8)
+	for i := 0; i < 200000; i++ {
+		for j := range word {
+			word[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+
+		temp, n, ok := swarTemp(loadWord(word))
+		if !ok {
+			continue
+		}
+
+		want, valid := parseTempChecked(word[:n])
+		require.True(t, valid, "%q", word)
+		require.Equal(t, want, temp, "%q", word)
+	}
+}
+
+func TestSwarMatch(t *testing.T) {
+	tt := []struct {
+		in   string
+		want int
+	}{
+		{"Abc;1.0\n", 3},
+		{";", 0},
+		{"Abcdefg;", 7},
+		{"\x3b\x3c", 0},
+		{"\x00;", 1},
+		{"Abcdefgh", 8},
+	}
+
+	for _, tc := range tt {
+		assert.Equal(t, tc.want, swarIndex(swarMatch(loadWord([]byte(tc.in)), semicolons)), "%q", tc.in)
+	}
+}
+
+// TestAddChunkBytewise checks that the SWAR scanning gives the same result as
+// the byte by byte one, including the lines that fall back to addLine.
+func TestAddChunkBytewise(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	temps := []string{"1.0", "-1.0", "12.3", "-12.3", "0.0", "22.77", "5.5\r", "7.1 "}
+
+	var in strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&in, "%s;%s\n", strings.Repeat("x", rng.Intn(20)+1), temps[rng.Intn(len(temps))])
+	}
+	in.WriteString("Last;9.9")
+
+	want := newProcessedBatch(defaultBatchSize)
+	require.NoError(t, want.addChunkBytewise([]byte(in.String())))
+
+	got := newProcessedBatch(defaultBatchSize)
+	require.NoError(t, got.addChunk([]byte(in.String())))
+
+	assert.Equal(t, batchStats(want), batchStats(got))
+}
@@ -0,0 +1,133 @@
+package brc
+
+import "bytes"
+
+type temprature struct {
+	min, max, sum, count int
+	sumSq                sumSquares
+	key                  []byte
+	hash                 uint64
+	hist                 *histogram // nil unless Aggregator.Percentiles is set
+}
+
+// processedBatch is an open addressing hash table keyed by station name.
+// Collisions are resolved with linear probing and the table doubles in size
+// once it is 3/4 full.
+type processedBatch struct {
+	slots      []temprature
+	mask       uint64
+	len        int
+	histograms bool
+
+	// ownKeys copies the names of new stations into the batch, for input
+	// that is unmapped before the batch is merged
+	ownKeys bool
+
+	// convert normalises the measurements of the validating parser to
+	// Celsius, nil when no unit conversion is needed
+	convert func(station []byte, temp int) int
+}
+
+// 16k slots keeps the load factor of the 413 stations from generate.go low
+// enough that most lookups land on the first probe.
+const defaultBatchSize = 1 << 14
+
+func newProcessedBatch(size int) processedBatch {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	return processedBatch{
+		slots: make([]temprature, n),
+		mask:  uint64(n - 1),
+	}
+}
+
+func (pb *processedBatch) add(station []byte, temp int) {
+	h := hash(station)
+
+	for i := h & pb.mask; ; i = (i + 1) & pb.mask {
+		bucket := &pb.slots[i]
+
+		if bucket.count == 0 {
+			if pb.ownKeys {
+				station = bytes.Clone(station)
+			}
+
+			*bucket = temprature{
+				min:   temp,
+				max:   temp,
+				sum:   temp,
+				count: 1,
+				key:   station,
+				hash:  h,
+			}
+			bucket.sumSq.addSquare(temp)
+
+			if pb.histograms {
+				bucket.hist = newHistogram()
+				bucket.hist.add(temp)
+			}
+
+			pb.len++
+			if pb.len*4 > len(pb.slots)*3 {
+				pb.grow()
+			}
+
+			return
+		}
+
+		// found the same station
+		if bucket.hash == h && bytes.Equal(bucket.key, station) {
+			bucket.min = min(bucket.min, temp)
+			bucket.max = max(bucket.max, temp)
+			bucket.sum += temp
+			bucket.sumSq.addSquare(temp)
+			bucket.count++
+			if bucket.hist != nil {
+				bucket.hist.add(temp)
+			}
+			return
+		}
+	}
+}
+
+// reset empties the table for reuse, keeping its slots.
+func (pb *processedBatch) reset() {
+	clear(pb.slots)
+	pb.len = 0
+}
+
+// grow doubles the table and re-inserts every occupied slot. The stored hash
+// is reused, so keys are not hashed again.
+func (pb *processedBatch) grow() {
+	old := pb.slots
+	pb.slots = make([]temprature, len(old)*2)
+	pb.mask = uint64(len(pb.slots) - 1)
+
+	for _, entry := range old {
+		if entry.count == 0 {
+			continue
+		}
+
+		i := entry.hash & pb.mask
+		for pb.slots[i].count != 0 {
+			i = (i + 1) & pb.mask
+		}
+
+		pb.slots[i] = entry
+	}
+}
+
+// hash is FNV-1a with the high bits folded into the low ones, since the
+// table index is taken from the low bits.
+func hash(key []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= 1099511628211
+	}
+
+	return hash ^ (hash >> 32)
+}
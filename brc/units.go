@@ -0,0 +1,144 @@
+package brc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Unit is a temperature scale.
+type Unit int
+
+const (
+	Celsius Unit = iota
+	Fahrenheit
+	Kelvin
+)
+
+var unitNames = map[Unit]string{
+	Celsius:    "C",
+	Fahrenheit: "F",
+	Kelvin:     "K",
+}
+
+func (u Unit) String() string {
+	if name, ok := unitNames[u]; ok {
+		return name
+	}
+
+	return "Unit(" + strconv.Itoa(int(u)) + ")"
+}
+
+// ParseUnit is the inverse of Unit.String. It also accepts the full names,
+// in any case.
+func ParseUnit(name string) (Unit, error) {
+	switch strings.ToLower(name) {
+	case "c", "celsius":
+		return Celsius, nil
+	case "f", "fahrenheit":
+		return Fahrenheit, nil
+	case "k", "kelvin":
+		return Kelvin, nil
+	}
+
+	return Celsius, fmt.Errorf("unknown unit %q", name)
+}
+
+// fromCelsius describes u as u = c*mul/div + offset, with offset in
+// hundredths of a degree.
+func (u Unit) fromCelsius() (mul, div, offset int) {
+	switch u {
+	case Fahrenheit:
+		return 9, 5, 3200
+	case Kelvin:
+		return 1, 1, 27315
+	}
+
+	return 1, 1, 0
+}
+
+// toCelsius converts a fixed-point temperature in u to Celsius, at the same
+// scale (which has to be at least hundredths), rounding half up.
+func (u Unit) toCelsius(v, scale int) int {
+	mul, div, offset := u.fromCelsius()
+	return roundHalfUp((v-offset*scale/100)*div, mul)
+}
+
+// conversion turns the fixed-point Celsius values of an aggregation into the
+// output unit and precision.
+type conversion struct {
+	unit     Unit
+	from, to int // decimals
+}
+
+// value converts a single value, rounding half up.
+func (c conversion) value(v int) int {
+	num, div := c.sum(v, 1)
+	return roundHalfUp(num, div)
+}
+
+// sum converts the sum of count values to the output unit, as the fraction
+// num/div so that it is exact. The offsets of Fahrenheit and Kelvin need from
+// to be at least 2.
+func (c conversion) sum(sum, count int) (num, div int) {
+	mul, div, offset := c.unit.fromCelsius()
+	offset = offset * pow10(c.from) / 100
+
+	return sum*mul + offset*div*count, div * pow10(c.from-c.to)
+}
+
+// factor is the ratio of a difference in the output unit to the same
+// difference in Celsius, for the variance and standard deviation.
+func (c conversion) factor() float64 {
+	mul, div, _ := c.unit.fromCelsius()
+	return float64(mul) / float64(div)
+}
+
+// LoadUnitMap reads the units of individual stations from the file at path,
+// one "<station>;<unit>" per line, for Aggregator.StationUnits. Empty lines
+// and lines starting with '#' are ignored.
+func LoadUnitMap(path string) (map[string]Unit, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fileError("open", path, err)
+	}
+	defer f.Close()
+
+	var (
+		units   = make(map[string]Unit)
+		scanner = bufio.NewScanner(f)
+		offset  int64
+	)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		start := offset
+		offset += int64(len(text)) + 1
+
+		text = bytes.TrimSpace(text)
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		splitIdx := bytes.LastIndexByte(text, ';')
+		if splitIdx <= 0 {
+			return nil, &ParseError{Path: path, Offset: start, Line: line, Reason: "expected <station>;<unit>"}
+		}
+
+		unit, err := ParseUnit(string(bytes.TrimSpace(text[splitIdx+1:])))
+		if err != nil {
+			return nil, &ParseError{Path: path, Offset: start, Line: line, Reason: err.Error()}
+		}
+
+		units[string(text[:splitIdx])] = unit
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fileError("read", path, err)
+	}
+
+	return units, nil
+}
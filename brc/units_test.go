@@ -0,0 +1,112 @@
+package brc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUnit(t *testing.T) {
+	for _, u := range []Unit{Celsius, Fahrenheit, Kelvin} {
+		got, err := ParseUnit(u.String())
+		require.NoError(t, err)
+		assert.Equal(t, u, got)
+	}
+
+	got, err := ParseUnit("Fahrenheit")
+	require.NoError(t, err)
+	assert.Equal(t, Fahrenheit, got)
+
+	_, err = ParseUnit("R")
+	assert.Error(t, err)
+}
+
+func TestUnitConversion(t *testing.T) {
+	tt := []struct {
+		unit      Unit
+		in, want  int // hundredths
+		roundTrip bool
+	}{
+		{Celsius, -1234, -1234, true},
+		{Fahrenheit, 3200, 0, true},
+		{Fahrenheit, 21200, 10000, true},
+		{Fahrenheit, -4000, -4000, true},
+		{Fahrenheit, 9860, 3700, true},
+		{Fahrenheit, 100, -1722, false}, // -17.2222...
+		{Kelvin, 0, -27315, true},
+		{Kelvin, 30000, 2685, true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.unit.String(), func(t *testing.T) {
+			c := tc.unit.toCelsius(tc.in, 100)
+			assert.Equal(t, tc.want, c)
+
+			if tc.roundTrip {
+				conv := conversion{unit: tc.unit, from: 2, to: 2}
+				assert.Equal(t, tc.in, conv.value(c))
+			}
+		})
+	}
+
+	// dropping a decimal rounds half up
+	conv := conversion{unit: Celsius, from: 2, to: 1}
+	assert.Equal(t, []int{13, -12, -13}, []int{conv.value(125), conv.value(-125), conv.value(-126)})
+}
+
+func TestAggregateUnits(t *testing.T) {
+	in := "Abc;212.0\nAbc;32\nJos;300.0\nHam;20.0\n"
+
+	agg := Aggregator{
+		InputUnit:    Fahrenheit,
+		StationUnits: map[string]Unit{"Jos": Kelvin, "Ham": Celsius},
+		Percentiles:  true,
+	}
+	res, err := agg.AggregateReader(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.Equal(t, Celsius, res.Unit)
+
+	var buf bytes.Buffer
+	require.NoError(t, newTextWriter(OutputOptions{}).WriteResult(&buf, res))
+	assert.Equal(t, "Abc=0.0/50.0/100.0\nHam=20.0/20.0/20.0\nJos=26.9/26.9/26.9\n", buf.String())
+
+	abc := res.Stations[0]
+	assert.InDelta(t, 2500.0, abc.Variance, 1e-9)
+	assert.Equal(t, 100.0, abc.P99)
+
+	// the other way round, with the rounding done once at the end
+	agg.OutputUnit = Fahrenheit
+	res, err = agg.AggregateReader(strings.NewReader(in))
+	require.NoError(t, err)
+
+	buf.Reset()
+	require.NoError(t, newTextWriter(OutputOptions{}).WriteResult(&buf, res))
+	assert.Equal(t, "Abc=32.0/122.0/212.0\nHam=68.0/68.0/68.0\nJos=80.3/80.3/80.3\n", buf.String())
+	assert.InDelta(t, 90.0*90.0, res.Stations[0].Variance, 1e-9)
+}
+
+func TestLoadUnitMap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "units.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# station;unit\nNew York;F\n\nPhoenix; fahrenheit \nVostok;K\n"), 0o644))
+
+	units, err := LoadUnitMap(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Unit{"New York": Fahrenheit, "Phoenix": Fahrenheit, "Vostok": Kelvin}, units)
+
+	require.NoError(t, os.WriteFile(path, []byte("Vostok;K\nOslo;X\n"), 0o644))
+	_, err = LoadUnitMap(path)
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 2, perr.Line)
+	assert.Equal(t, int64(9), perr.Offset)
+
+	_, err = LoadUnitMap(filepath.Join(dir, "missing"))
+	var ferr *FileError
+	assert.ErrorAs(t, err, &ferr)
+}
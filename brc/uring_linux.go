@@ -0,0 +1,261 @@
+package brc
+
+import (
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// A minimal io_uring, with just what reading windows needs: one ring per
+// worker, IORING_OP_READ requests submitted in a batch and reaped with the
+// same io_uring_enter call. See io_uring_setup(2) and io_uring_enter(2) for
+// the layout of the rings.
+
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpRead         = 22
+	ioringEnterGetEvents = 1
+	ioringFeatSingleMmap = 1
+	uringEntries         = 16
+	uringPieceSize       = 256 << 10
+	uringSQESize         = 64
+	uringCQESize         = 16
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	_        [24]byte
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringReader reads a window in pieces of uringPieceSize, all submitted at
+// once, into a buffer that is reused.
+type uringReader struct {
+	fd     int
+	params uringParams
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+	buf    []byte
+}
+
+func newURingReader() (segmentReader, error) {
+	r := &uringReader{}
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uringEntries, uintptr(unsafe.Pointer(&r.params)), 0)
+	if errno != 0 {
+		return nil, &FileError{Op: "io_uring_setup", Err: errno}
+	}
+	r.fd = int(fd)
+
+	p := &r.params
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uringCQESize)
+	if p.features&ioringFeatSingleMmap != 0 {
+		sqSize = max(sqSize, cqSize)
+	}
+
+	var err error
+	if r.sqRing, err = uringMmap(r.fd, ioringOffSQRing, sqSize); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	r.cqRing = r.sqRing
+	if p.features&ioringFeatSingleMmap == 0 {
+		if r.cqRing, err = uringMmap(r.fd, ioringOffCQRing, cqSize); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+
+	if r.sqes, err = uringMmap(r.fd, ioringOffSQEs, int(p.sqEntries)*uringSQESize); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func uringMmap(fd int, offset int64, size int) ([]byte, error) {
+	b, err := syscall.Mmap(fd, offset, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return nil, &MmapError{Path: "io_uring", Err: err}
+	}
+
+	return b, nil
+}
+
+func (r *uringReader) read(f mappedFile, lo, hi int) ([]byte, error) {
+	if cap(r.buf) < hi-lo {
+		r.buf = make([]byte, hi-lo)
+	}
+	window := r.buf[:hi-lo]
+
+	// pieces still to read, by start; short reads are resubmitted for the
+	// rest of the piece
+	pending := make(map[int]int)
+	for start := 0; start < len(window); start += uringPieceSize {
+		pending[start] = min(start+uringPieceSize, len(window))
+	}
+
+	// size shrinks if the file was truncated while it was read
+	size := len(window)
+
+	for len(pending) > 0 {
+		submitted := 0
+		for start, end := range pending {
+			if submitted == int(r.params.sqEntries) {
+				break
+			}
+			r.push(uringSQE{
+				opcode:   ioringOpRead,
+				fd:       int32(f.file.Fd()),
+				off:      uint64(lo + start),
+				addr:     uint64(uintptr(unsafe.Pointer(&window[start]))),
+				len:      uint32(end - start),
+				userData: uint64(start),
+			})
+			submitted++
+		}
+
+		if err := r.enter(submitted); err != nil {
+			r.drop()
+			return nil, fileError("read", f.path, err)
+		}
+
+		// every completion is reaped, even after a failure, so that none is
+		// left over for the next window
+		var err error
+		for i := 0; i < submitted; i++ {
+			cqe := r.pop()
+			start := int(cqe.userData)
+			end := pending[start]
+			delete(pending, start)
+
+			switch {
+			case cqe.res < 0:
+				if err == nil {
+					err = syscall.Errno(-cqe.res)
+				}
+			case cqe.res == 0:
+				size = min(size, start)
+			case start+int(cqe.res) < end:
+				pending[start+int(cqe.res)] = end
+			}
+		}
+
+		if err != nil {
+			return nil, fileError("read", f.path, err)
+		}
+
+		for start := range pending {
+			if start >= size {
+				delete(pending, start)
+			}
+		}
+	}
+
+	return window[:size], nil
+}
+
+// drop skips the completions of a failed submission.
+func (r *uringReader) drop() {
+	off := r.params.cqOff
+	atomic.StoreUint32(r.uint32At(r.cqRing, off.head), atomic.LoadUint32(r.uint32At(r.cqRing, off.tail)))
+}
+
+func (r *uringReader) uint32At(ring []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[off]))
+}
+
+// push queues sqe, without submitting it.
+func (r *uringReader) push(sqe uringSQE) {
+	off := r.params.sqOff
+	tail := atomic.LoadUint32(r.uint32At(r.sqRing, off.tail))
+	idx := tail & *r.uint32At(r.sqRing, off.ringMask)
+
+	*(*uringSQE)(unsafe.Pointer(&r.sqes[idx*uringSQESize])) = sqe
+	*r.uint32At(r.sqRing, off.array+idx*4) = idx
+	atomic.StoreUint32(r.uint32At(r.sqRing, off.tail), tail+1)
+}
+
+// enter submits the queued requests and waits for n completions.
+func (r *uringReader) enter(n int) error {
+	off := r.params.sqOff
+	for {
+		queued := atomic.LoadUint32(r.uint32At(r.sqRing, off.tail)) - atomic.LoadUint32(r.uint32At(r.sqRing, off.head))
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(queued), uintptr(n), ioringEnterGetEvents, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		}
+
+		return errno
+	}
+}
+
+// pop takes a completion, which enter has waited for.
+func (r *uringReader) pop() uringCQE {
+	off := r.params.cqOff
+
+	head := atomic.LoadUint32(r.uint32At(r.cqRing, off.head))
+	idx := head & *r.uint32At(r.cqRing, off.ringMask)
+	cqe := *(*uringCQE)(unsafe.Pointer(&r.cqRing[off.cqes+idx*uringCQESize]))
+	atomic.StoreUint32(r.uint32At(r.cqRing, off.head), head+1)
+
+	return cqe
+}
+
+func (r *uringReader) release() {}
+
+func (r *uringReader) close() error {
+	if r.sqes != nil {
+		syscall.Munmap(r.sqes)
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		syscall.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		syscall.Munmap(r.sqRing)
+	}
+
+	return syscall.Close(r.fd)
+}
@@ -0,0 +1,85 @@
+package brc
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURingReader(t *testing.T) {
+	skipWithoutIOURing(t)
+
+	// more pieces than entries in the ring, and a window that is not a
+	// multiple of them
+	data := make([]byte, 2*uringEntries*uringPieceSize+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := newSegmentReader(IOUring)
+	require.NoError(t, err)
+	defer r.close()
+
+	file := mappedFile{path: path, size: len(data), file: f}
+	for _, window := range [][2]int{{0, len(data)}, {4096, 4096 + 100}, {len(data) - 10, len(data)}} {
+		got, err := r.read(file, window[0], window[1])
+		require.NoError(t, err)
+		assert.Equal(t, data[window[0]:window[1]], got)
+	}
+}
+
+func TestURingReaderReuse(t *testing.T) {
+	skipWithoutIOURing(t)
+
+	dir := t.TempDir()
+	data := make([]byte, 3*uringPieceSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	d, err := os.Open(dir)
+	require.NoError(t, err)
+	defer d.Close()
+
+	r, err := newSegmentReader(IOUring)
+	require.NoError(t, err)
+	defer r.close()
+
+	file := mappedFile{path: path, size: len(data), file: f}
+	read := func(lo, hi int) {
+		t.Helper()
+		got, err := r.read(file, lo, hi)
+		require.NoError(t, err)
+		assert.Equal(t, data[lo:hi], got)
+	}
+
+	// every piece fails, the completions of all of them have to be reaped
+	// before the next window is read
+	_, err = r.read(mappedFile{path: dir, file: d}, 0, len(data))
+	assert.ErrorIs(t, err, syscall.EISDIR)
+	read(0, len(data))
+
+	// a file shorter than the window, as if it was truncated while read:
+	// the pieces past its end read nothing
+	got, err := r.read(file, uringPieceSize/2, 2*len(data))
+	require.NoError(t, err)
+	assert.Equal(t, data[uringPieceSize/2:], got)
+	read(0, len(data))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package brc
+
+import "errors"
+
+func newURingReader() (segmentReader, error) {
+	return nil, &FileError{Op: "io_uring_setup", Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,165 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// ErrorPolicy selects the parser and what it does with malformed lines.
+type ErrorPolicy int
+
+const (
+	// Unchecked is the fast parser. It trusts the input to be well formed:
+	// lines it cannot split stop the run with a *ParseError, but garbage
+	// digits, empty lines and lines shorter than the smallest valid one are
+	// not detected.
+	Unchecked ErrorPolicy = iota
+
+	// Fail validates every line and stops at the first malformed one.
+	Fail
+
+	// Skip validates every line, drops the malformed ones and counts them in
+	// Result.Rejected.
+	Skip
+
+	// Report is Skip, but also records the malformed lines in
+	// Result.Rejections.
+	Report
+)
+
+var errorPolicyNames = map[ErrorPolicy]string{
+	Unchecked: "unchecked",
+	Fail:      "fail",
+	Skip:      "skip",
+	Report:    "report",
+}
+
+func (p ErrorPolicy) String() string {
+	if name, ok := errorPolicyNames[p]; ok {
+		return name
+	}
+
+	return "ErrorPolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParseErrorPolicy is the inverse of ErrorPolicy.String.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	for p, n := range errorPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+
+	return Unchecked, fmt.Errorf("unknown error policy %q", name)
+}
+
+// rejectedLines are the malformed lines a chunk skipped. lines is only
+// filled in with the Report policy.
+type rejectedLines struct {
+	count int
+	lines []*ParseError
+}
+
+// tempParser parses a temperature into its fixed-point value.
+type tempParser func(s []byte) (int, bool)
+
+// lineChecker is the configuration of the validating parser.
+type lineChecker struct {
+	layout      layout
+	parse       tempParser
+	policy      ErrorPolicy
+	maxReported int
+}
+
+// addChunkChecked is the validating counterpart of addChunk. Every line has
+// to have a non-empty station and a temperature accepted by c.parse, where
+// c.layout says. Malformed lines are handled according to c.policy,
+// recording at most c.maxReported of them. Offsets and line numbers are
+// relative to the chunk.
+func (pb *processedBatch) addChunkChecked(chunk []byte, c *lineChecker) (rejectedLines, error) {
+	var rejected rejectedLines
+
+	for start, line := 0, 1; start < len(chunk); line++ {
+		end := bytes.IndexByte(chunk[start:], '\n')
+		if end < 0 {
+			end = len(chunk)
+		} else {
+			end += start
+		}
+
+		station, temp, reason := c.checkLine(trimLineEnd(chunk[start:end]))
+		if reason == "" {
+			if pb.convert != nil {
+				temp = pb.convert(station, temp)
+			}
+			pb.add(station, temp)
+			start = end + 1
+			continue
+		}
+
+		perr := &ParseError{Offset: int64(start), Line: line, Reason: reason}
+		if c.policy == Fail {
+			return rejected, perr
+		}
+
+		rejected.count++
+		if c.policy == Report && len(rejected.lines) < c.maxReported {
+			rejected.lines = append(rejected.lines, perr)
+		}
+
+		start = end + 1
+	}
+
+	return rejected, nil
+}
+
+// checkLine splits and validates a single line, without the \n. reason is
+// empty for a valid line.
+func (c *lineChecker) checkLine(line []byte) (station []byte, temp int, reason string) {
+	if len(line) == 0 {
+		return nil, 0, "empty line"
+	}
+
+	station, value, reason := c.layout.split(line)
+	if reason != "" {
+		return nil, 0, reason
+	}
+
+	if len(station) == 0 {
+		return nil, 0, "empty station name"
+	}
+
+	temp, ok := c.parse(value)
+	switch {
+	case !ok:
+		return nil, 0, "invalid temperature " + strconv.Quote(string(value))
+	case temp > maxFixed || temp < -maxFixed:
+		return nil, 0, "temperature out of range " + strconv.Quote(string(value))
+	}
+
+	return station, temp, ""
+}
+
+// parseTempChecked is parseTemp for input that is not trusted: it only
+// accepts [-]d.d and [-]dd.d.
+func parseTempChecked(s []byte) (int, bool) {
+	mul := 1
+	if len(s) > 0 && s[0] == '-' {
+		mul = -1
+		s = s[1:]
+	}
+
+	switch {
+	case len(s) == 3 && isDigit(s[0]) && s[1] == '.' && isDigit(s[2]):
+		return (int(s[0]-'0')*10 + int(s[2]-'0')) * mul, true
+	case len(s) == 4 && isDigit(s[0]) && isDigit(s[1]) && s[2] == '.' && isDigit(s[3]):
+		return (int(s[0]-'0')*100 + int(s[1]-'0')*10 + int(s[3]-'0')) * mul, true
+	}
+
+	return 0, false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
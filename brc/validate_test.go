@@ -0,0 +1,124 @@
+package brc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLine(t *testing.T) {
+	tt := []struct {
+		in      string
+		station string
+		temp    int
+		reason  string
+	}{
+		{"Abc;12.0", "Abc", 120, ""},
+		{"A;-1.2", "A", -12, ""},
+		{"A;b;-99.9", "A;b", -999, ""},
+		{"", "", 0, "empty line"},
+		{"Abc", "", 0, "missing ';'"},
+		{";1.0", "", 0, "empty station name"},
+		{"Abc;", "", 0, `invalid temperature ""`},
+		{"Abc;-", "", 0, `invalid temperature "-"`},
+		{"Abc;1", "", 0, `invalid temperature "1"`},
+		{"Abc;1.", "", 0, `invalid temperature "1."`},
+		{"Abc;.1", "", 0, `invalid temperature ".1"`},
+		{"Abc;22.77", "", 0, `invalid temperature "22.77"`},
+		{"Abc;100.0", "", 0, `invalid temperature "100.0"`},
+		{"Abc;1a.0", "", 0, `invalid temperature "1a.0"`},
+		{"Abc;1,0", "", 0, `invalid temperature "1,0"`},
+		{"Abc;--1.0", "", 0, `invalid temperature "--1.0"`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			c := lineChecker{layout: defaultLayout, parse: parseTempChecked}
+			station, temp, reason := c.checkLine([]byte(tc.in))
+			assert.Equal(t, tc.reason, reason)
+			assert.Equal(t, tc.station, string(station))
+			assert.Equal(t, tc.temp, temp)
+		})
+	}
+}
+
+// malformedInput has a malformed line every 10 lines, starting at line 5.
+func malformedInput() string {
+	var in strings.Builder
+	for i := 1; i <= 100; i++ {
+		if i%10 == 5 {
+			in.WriteString("Abc;x\n")
+			continue
+		}
+		fmt.Fprintf(&in, "Jos;%d.%d\n", i%50, i%10)
+	}
+
+	return in.String()
+}
+
+func TestErrorPolicies(t *testing.T) {
+	in := malformedInput()
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			agg := Aggregator{Workers: workers, BufferSize: 64, OnError: Fail}
+			_, err := agg.AggregateReader(strings.NewReader(in))
+
+			var perr *ParseError
+			require.ErrorAs(t, err, &perr)
+			assert.Equal(t, 5, perr.Line)
+			assert.Equal(t, `invalid temperature "x"`, perr.Reason)
+
+			agg.OnError = Skip
+			res, err := agg.AggregateReader(strings.NewReader(in))
+			require.NoError(t, err)
+			assert.Equal(t, 10, res.Rejected)
+			assert.Empty(t, res.Rejections)
+			assert.Equal(t, 90, res.Stations[0].Count)
+
+			agg.OnError = Report
+			agg.MaxReported = 4
+			res, err = agg.AggregateReader(strings.NewReader(in))
+			require.NoError(t, err)
+			assert.Equal(t, 10, res.Rejected)
+			require.Len(t, res.Rejections, 4)
+			for i, perr := range res.Rejections {
+				assert.Equal(t, i*10+5, perr.Line)
+			}
+		})
+	}
+}
+
+func TestErrorPoliciesFiles(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	require.NoError(t, os.WriteFile(first, []byte(malformedInput()), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("Jos;1.0\n\nJos;2.0"), 0o600))
+
+	agg := Aggregator{Workers: 4, OnError: Report}
+	res, err := agg.AggregateFiles(second, first)
+	require.NoError(t, err)
+	assert.Equal(t, 11, res.Rejected)
+	require.Len(t, res.Rejections, 11)
+
+	assert.Equal(t, &ParseError{Path: second, Offset: 8, Line: 2, Reason: "empty line"}, res.Rejections[0])
+	assert.Equal(t, first, res.Rejections[1].Path)
+	assert.Equal(t, 5, res.Rejections[1].Line)
+	assert.Equal(t, 95, res.Rejections[10].Line)
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, p := range []ErrorPolicy{Unchecked, Fail, Skip, Report} {
+		got, err := ParseErrorPolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+
+	_, err := ParseErrorPolicy("ignore")
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package brc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile reports changes to the file at path on the returned channel,
+// coalescing the ones that were not received yet. The directory of path is
+// watched rather than the file, so that a file created in its place after a
+// rotation is seen too.
+func watchFile(path string) (<-chan struct{}, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, &FileError{Op: "inotify_init", Path: path, Err: err}
+	}
+
+	const mask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		return nil, nil, &FileError{Op: "inotify_add_watch", Path: filepath.Dir(path), Err: err}
+	}
+
+	// a non-blocking fd in an *os.File goes through the poller, so closing
+	// it stops the read below
+	file := os.NewFile(uintptr(fd), "inotify")
+	events := make(chan struct{}, 1)
+	name := []byte(filepath.Base(path))
+
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameEnd := off + syscall.SizeofInotifyEvent + int(event.Len)
+				eventName := bytes.TrimRight(buf[off+syscall.SizeofInotifyEvent:nameEnd], "\x00")
+				off = nameEnd
+
+				if !bytes.Equal(eventName, name) {
+					continue
+				}
+
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return events, func() { file.Close() }, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package brc
+
+// watchFile has nothing to watch with, so the file is only polled.
+func watchFile(path string) (<-chan struct{}, func(), error) {
+	return nil, func() {}, nil
+}
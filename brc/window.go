@@ -0,0 +1,76 @@
+package brc
+
+import (
+	"os"
+	"syscall"
+)
+
+// minWindowSegment keeps the segments of a small MemoryBudget from being so
+// small that loading them dominates.
+const minWindowSegment = 64 << 10
+
+// load reads the segment of a file that c nominally covers with r, from the
+// page holding the byte before it to a little past its end, and aligns it to
+// lines like alignSegment. The window grows until it holds the \n ending the
+// segment's last line. The data stays valid until r.release.
+func (c fileChunk) load(r segmentReader) (fileChunk, error) {
+	var (
+		f    = c.file
+		page = os.Getpagesize()
+		lo   = max(c.offset-1, 0) / page * page
+	)
+
+	for slack := page; ; slack *= 2 {
+		hi := min(c.end+slack, f.size)
+		window, err := r.read(f, lo, hi)
+		if err != nil {
+			return c, err
+		}
+
+		end := alignSegment(window, c.end-lo)
+		if end == len(window) && hi < f.size {
+			r.release()
+			continue
+		}
+
+		start := alignSegment(window, c.offset-lo)
+		c.offset = lo + start
+		c.data = window[start:max(start, end)]
+
+		return c, nil
+	}
+}
+
+// mmapReader maps every window on its own.
+type mmapReader struct {
+	mapping []byte
+}
+
+func (r *mmapReader) read(f mappedFile, lo, hi int) ([]byte, error) {
+	window, err := syscall.Mmap(int(f.file.Fd()), int64(lo), hi-lo, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &MmapError{Path: f.path, Err: err}
+	}
+
+	adviseSequential(window)
+	r.mapping = window
+
+	return window, nil
+}
+
+// release unmaps the window, dropping its pages right away rather than
+// leaving them to the kernel.
+func (r *mmapReader) release() {
+	if r.mapping == nil {
+		return
+	}
+
+	adviseDontNeed(r.mapping)
+	syscall.Munmap(r.mapping)
+	r.mapping = nil
+}
+
+func (r *mmapReader) close() error {
+	r.release()
+	return nil
+}
@@ -0,0 +1,98 @@
+package brc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// windowedInput is ~1 MiB of measurements with a malformed line every 997
+// lines and a station name longer than a segment in the middle.
+func windowedInput() string {
+	var in strings.Builder
+	in.WriteString("\xEF\xBB\xBF")
+	for i := 0; in.Len() < 1<<20; i++ {
+		switch {
+		case i == 20000:
+			fmt.Fprintf(&in, "%s;1.0\r\n", strings.Repeat("x", 3*minWindowSegment))
+		case i%997 == 0:
+			in.WriteString("Jos;x\n")
+		default:
+			fmt.Fprintf(&in, "Station %d;%d.%d\n", i%101, i%100-50, i%10)
+		}
+	}
+
+	return in.String()
+}
+
+func TestAggregateWindowed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte(windowedInput()), 0o600))
+
+	small := filepath.Join(dir, "small.txt")
+	require.NoError(t, os.WriteFile(small, []byte("Jos;3.9\nJos;x"), 0o600))
+
+	want, err := (&Aggregator{OnError: Report}).AggregateFiles(path, small)
+	require.NoError(t, err)
+	require.Len(t, want.Stations, 103)
+
+	for _, workers := range []int{1, 3} {
+		for _, budget := range []int{1, 1 << 20, 1 << 30} {
+			t.Run(fmt.Sprintf("%d/%d", workers, budget), func(t *testing.T) {
+				agg := Aggregator{Workers: workers, MemoryBudget: budget, OnError: Report}
+				res, err := agg.AggregateFiles(path, small)
+				require.NoError(t, err)
+				assert.Equal(t, want, res)
+			})
+		}
+	}
+}
+
+func TestLoadWindow(t *testing.T) {
+	in := windowedInput()
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(path, []byte(in), 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	file := mappedFile{path: path, size: len(in), file: f}
+	s := newSegmenter([]mappedFile{file}, minWindowSegment)
+
+	var (
+		got strings.Builder
+		r   = &mmapReader{}
+	)
+	for c, ok := s.next(); ok; c, ok = s.next() {
+		c, err := c.load(r)
+		require.NoError(t, err)
+
+		assert.Equal(t, in[c.offset:c.offset+len(c.data)], string(c.data))
+		if !strings.Contains(string(r.mapping), "xxxxx") {
+			// a page on either side, unless a line is longer than that
+			assert.LessOrEqual(t, len(r.mapping), minWindowSegment+2*os.Getpagesize())
+		}
+
+		got.Write(c.data)
+		r.release()
+	}
+
+	assert.Equal(t, in[3:], got.String())
+}
+
+func TestAggregateWindowedCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.txt.gz")
+	require.NoError(t, os.WriteFile(path, gzipMembers(t, []byte("Jos;3.9\nJos;-3.9\n"), 2, 6), 0o600))
+
+	agg := Aggregator{MemoryBudget: 1}
+	res, err := agg.AggregateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Stations[0].Count)
+}
@@ -0,0 +1,142 @@
+package brc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Work serves a Coordinator over rw, typically a TCP connection to it, until
+// the coordinator has no more ranges for it. The ranges are aggregated with
+// the parse settings of the coordinator (see Coordinator.Aggregator) and a's
+// Workers, SegmentSize, MemoryBudget and Backend, so every worker can be
+// tuned to its machine. The files have to be at the same paths on the
+// workers as on the coordinator.
+func (a *Aggregator) Work(rw io.ReadWriter) error {
+	c := newConn(rw)
+
+	hello := binary.AppendUvarint([]byte(protocolMagic), protocolVersion)
+	if err := c.send(msgHello, hello); err != nil {
+		return err
+	}
+
+	settings, err := c.expect(msgSettings)
+	if err != nil {
+		return err
+	}
+
+	w := *a
+	if err := w.applySettings(settings); err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+
+	for {
+		typ, payload, err := c.receive()
+		if err == io.EOF {
+			return errors.New("the coordinator closed the connection")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case msgDone:
+			return nil
+		case msgAssign:
+		default:
+			return fmt.Errorf("unexpected message %d", typ)
+		}
+
+		d := snapshotDecoder{data: payload}
+		id, path, lo, hi := d.uvarint(), d.string(), d.int(0, -1), d.int(0, -1)
+		if d.err != nil {
+			return fmt.Errorf("assign: %w", d.err)
+		}
+
+		reply := binary.AppendUvarint(nil, id)
+		agg, err := w.aggregateRange(path, lo, hi)
+		if err != nil {
+			err = c.send(msgFailed, appendError(reply, err))
+		} else {
+			snap := &Snapshot{agg: agg, conv: w.conversion(), histograms: w.Percentiles}
+			err = c.send(msgResult, snap.appendBinary(reply))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// aggregateRange aggregates the lines of the file at path that start
+// between lo and hi. Compressed files cannot be split, and are aggregated
+// whole.
+func (a *Aggregator) aggregateRange(path string, lo, hi int) (*aggregation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fileError("open", path, err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fileError("stat", path, err)
+	}
+	size := int(stat.Size())
+
+	head := make([]byte, 4)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fileError("read", path, err)
+	}
+
+	if detectCompression(path, head[:n]) != uncompressed {
+		_, agg, err := a.openFile(path)
+		if err == nil && agg.firstErr != nil {
+			err = agg.firstErr
+		}
+
+		return agg, err
+	}
+
+	start, err := lineStart(file, lo, size)
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+	end, err := lineStart(file, min(hi, size), size)
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+
+	agg := newAggregation()
+	if end > start {
+		a.aggregateMapped(agg, []mappedFile{{path: path, start: start, size: end, file: file}})
+	}
+
+	return agg, agg.firstErr
+}
+
+// lineStart is alignSegment for a file that is not mapped: the start of the
+// line that owns the byte at offset at, reading forward from there.
+func lineStart(f *os.File, at, size int) (int, error) {
+	buf := make([]byte, 64<<10)
+	if at == 0 {
+		n, err := f.ReadAt(buf[:min(len(utf8BOM), size)], 0)
+		return bomLen(buf[:n]), err
+	}
+
+	for lo := at - 1; lo < size; lo += len(buf) {
+		n, err := f.ReadAt(buf[:min(len(buf), size-lo)], int64(lo))
+		if err != nil {
+			return 0, err
+		}
+
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return lo + i + 1, nil
+		}
+	}
+
+	return size, nil
+}
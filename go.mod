@@ -2,7 +2,10 @@ module github.com/arjunmahishi/1brcgo
 
 go 1.21.1
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/klauspost/compress v1.17.9
+	github.com/stretchr/testify v1.9.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -1,248 +1,410 @@
-/*
-If you want to see the tests and benchmarks that led to this, checkout
-this repo: https://github.com/arjunmahishi/1brcgo
-
-NOTE: this solution only works for the 413 stations generated by the
-generate.go script :P. There is a function in hash_test.go (https://github.com/arjunmahishi/1brcgo/blob/a17e34a5543bcdfcedd7da9c1c862d3e1b1212b7/hash_test.go#L76)
-which finds the right "mod" (len(arr) % mod) for a zero collision hash function.
-
-Some of the most impactful optimisations in this solution:
-  * Manually splitting text (instead of bytes.Split). Apart from reducing
-    allocations, it also lets you skip a few iterations based on the smallest
-    station name and smallest temperature length
-  * Mmap did not have a significant impact on performance. It was probably
-    the same as reading the file in chunks concurrently.
-  * The custom hash function was not as impactful as I thought it would be.
-    Just saved a few hundred milliseconds.
-  * String conversion using unsafe was a lot faster than using string(someByteSlice) because
-    it reuses the already allocated memory for the byte slice.
-  * Avoiding strings.ParseFloat/Atoi saved a lot of time. I was able to parse
-    the temperature as an int directly from the byte slice by transposing the
-    ASCII values of each character to it's integer counterpart.
-  * No locks or waitgroups were used. The fan-in of processed chunks was done
-    using a single channel and a counter.
-*/
-
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"math"
+	"net"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
-	"unsafe"
-)
+	"time"
 
-type temprature struct {
-	min, max, sum, count int
-	key                  []byte
-}
+	"github.com/arjunmahishi/1brcgo/brc"
+)
 
-type processedBatch []temprature
+// exit codes, one per kind of failure so that job runners can tell them apart
+const (
+	exitOK = iota
+	exitUnknown
+	exitFile
+	exitMmap
+	exitParse
+	exitUsage
+)
 
 func main() {
+	os.Exit(cli(os.Args[1:]))
+}
+
+func cli(args []string) int {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	if len(args) > 0 && args[0] == "merge" {
+		return mergeCLI(args[1:])
+	}
+	if len(args) > 0 && args[0] == "worker" {
+		return workerCLI(args[1:])
+	}
+	serve := len(args) > 0 && args[0] == "serve"
+	if serve {
+		args = args[1:]
+	}
+
+	var (
+		agg      brc.Aggregator
+		output   brc.OutputOptions
+		format   string
+		onError  string
+		decimals int
+		inUnit   string
+		outUnit  string
+		unitMap  string
+		delim    string
+		backend  string
+		snapshot string
+		ckpt     string
+		follow   bool
+		follows  brc.FollowOptions
+		coord    brc.Coordinator
+		listen   string
+		addr     string
+	)
+
+	flags := flag.NewFlagSet("1brcgo", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: 1brcgo [flags] [file|glob|dir|-]...")
+		fmt.Fprintln(flags.Output(), "       1brcgo merge [flags] snapshot...")
+		fmt.Fprintln(flags.Output(), "       1brcgo worker [flags] coordinator-address")
+		fmt.Fprintln(flags.Output(), "       1brcgo serve [flags] [file|glob|dir|snapshot]...")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", "text", "output format: "+strings.Join(brc.Formats(), ", "))
+	flags.StringVar(&onError, "on-error", "", "validate every line, and fail, skip or report (skip and list on stderr) malformed ones. The fast parser trusts the input if unset")
+	flags.BoolVar(&output.Percentiles, "percentiles", false, "also print the median, p90, p95 and p99 of every station")
+	flags.BoolVar(&output.StdDev, "stddev", false, "also print the variance and standard deviation of every station")
+	flags.StringVar(&inUnit, "input-unit", "C", "unit of the measurements: C, F or K")
+	flags.StringVar(&outUnit, "output-unit", "C", "unit of the output: C, F or K")
+	flags.StringVar(&unitMap, "unit-map", "", "`file` of <station>;<unit> lines overriding -input-unit for individual stations")
+	flags.StringVar(&delim, "delimiter", ";", "field delimiter: a single character, or semicolon, comma, tab or pipe")
+	flags.IntVar(&agg.KeyField, "key-field", 0, "1-based field of the station in wider records (default 1)")
+	flags.IntVar(&agg.ValueField, "value-field", 0, "1-based field of the temperature in wider records (default 2)")
+	flags.IntVar(&agg.SegmentSize, "segment-size", 0, "size in bytes of the segments files are cut into for the workers (default 4 MiB, -1 for one per worker)")
+	flags.StringVar(&backend, "io", "mmap", "how files are read: mmap, pread or io_uring (Linux)")
+	flags.Func("memory-budget", "map at most this many bytes of the input at a time (e.g. 512M, 2G); files are mapped whole if unset", func(v string) error {
+		n, err := parseSize(v)
+		agg.MemoryBudget = n
+		return err
+	})
+	flags.StringVar(&snapshot, "snapshot", "", "also save the aggregated state to `file`, for 1brcgo merge")
+	flags.StringVar(&ckpt, "checkpoint", "", "only aggregate what was appended to the inputs since the last run with the same checkpoint `file`, and update it")
+	flags.BoolVar(&follow, "follow", false, "keep following the input file as it grows, like tail -F, and print the result every -interval")
+	flags.DurationVar(&follows.Interval, "interval", time.Second, "time between two results with -follow")
+	flags.BoolVar(&follows.Changed, "changed", false, "with -follow, only print the stations that changed since the previous result")
+	flags.StringVar(&listen, "coordinate", "", "hand the input out to 1brcgo worker processes connecting to this `address` (e.g. :7070) instead of aggregating it here")
+	flags.Func("range-size", "size of the ranges handed out to the workers with -coordinate (default 64M)", func(v string) error {
+		n, err := parseSize(v)
+		coord.RangeSize = n
+		return err
+	})
+	flags.StringVar(&addr, "addr", ":8080", "`address` 1brcgo serve listens on")
+	flags.IntVar(&decimals, "decimals", 1, "precision of the temperatures, at most 6 (5 when converting units); if set, integers and any number of decimals are read and every line is validated")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+	args = flags.Args()
+	agg.Percentiles = output.Percentiles
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "decimals" {
+			agg.Decimals = decimals
+			if decimals == 0 {
+				agg.Decimals = brc.NoDecimals
+			}
+		}
+	})
+	if decimals < 0 {
+		fmt.Fprintln(os.Stderr, "-decimals must not be negative")
+		flags.Usage()
+		return exitUsage
+	}
+
+	writer, err := brc.NewResultWriter(format, output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	if onError != "" {
+		if agg.OnError, err = brc.ParseErrorPolicy(onError); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flags.Usage()
+			return exitUsage
+		}
+	}
+
+	if agg.InputUnit, err = brc.ParseUnit(inUnit); err == nil {
+		agg.OutputUnit, err = brc.ParseUnit(outUnit)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	if agg.Backend, err = brc.ParseIOBackend(backend); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	if agg.Delimiter, err = brc.ParseDelimiter(delim); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	if unitMap != "" {
+		if agg.StationUnits, err = brc.LoadUnitMap(unitMap); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCode(err)
+		}
+	}
+
+	if err := agg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
 	if os.Getenv("PROFILE") == "1" {
 		fmt.Println(runtime.NumCPU(), "CPUs available")
 		cpuProfile, err := os.Create("cpu_profile.prof")
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitUnknown
 		}
 		defer cpuProfile.Close()
 
 		if err := pprof.StartCPUProfile(cpuProfile); err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitUnknown
 		}
 		defer pprof.StopCPUProfile()
 	}
 
-	filename := "measurements.txt"
-	if len(os.Args) > 1 {
-		filename = os.Args[1]
+	inputs := []string{"measurements.txt"}
+	if len(args) > 0 {
+		inputs = args
 	}
 
-	run(filename)
-}
+	if serve {
+		if follow || ckpt != "" || snapshot != "" || listen != "" || slices.Contains(inputs, "-") {
+			fmt.Fprintln(os.Stderr, "1brcgo serve cannot read stdin or be combined with -follow, -checkpoint, -snapshot or -coordinate")
+			flags.Usage()
+			return exitUsage
+		}
 
-func run(filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
+		if err := runServer(&agg, addr, inputs, output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCode(err)
+		}
 
-	stat, err := file.Stat()
-	if err != nil {
-		panic(err)
+		return exitOK
 	}
 
-	data, err := syscall.Mmap(
-		int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED,
-	)
-	if err != nil {
-		panic(err)
+	if listen != "" {
+		if follow || ckpt != "" {
+			fmt.Fprintln(os.Stderr, "-coordinate cannot be combined with -follow or -checkpoint")
+			flags.Usage()
+			return exitUsage
+		}
+
+		coord.Aggregator = agg
+		if err := runCoordinator(&coord, listen, inputs, writer, snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCode(err)
+		}
+
+		return exitOK
 	}
 
-	var (
-		noOfChunks  = runtime.NumCPU()
-		chunkSize   = len(data) / noOfChunks
-		start       = 0
-		resChan     = make(chan processedBatch, noOfChunks)
-		actualCount = 0
-	)
+	if follow {
+		if len(inputs) != 1 || snapshot != "" || ckpt != "" {
+			fmt.Fprintln(os.Stderr, "-follow takes a single file, and no -snapshot or -checkpoint")
+			flags.Usage()
+			return exitUsage
+		}
 
-	for i := 0; i < noOfChunks && start < len(data); i++ {
-		end := min(start+chunkSize, len(data)-1)
-
-		// find the nearest \n
-		for {
-			if data[end] == '\n' || end == len(data)-1 {
-				go func(chunk []byte) {
-					resChan <- handleChunk(chunk)
-				}(data[start:end])
-				actualCount++
-				start = end + 1
-				break
-			}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-			end++
+		if err := agg.Follow(ctx, inputs[0], follows, func(res *brc.Result) error {
+			return report(writer, res)
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCode(err)
 		}
+
+		return exitOK
 	}
 
-	aggAndPrint(resChan, actualCount)
+	if err := run(&agg, inputs, writer, snapshot, ckpt); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	return exitOK
 }
 
-func aggAndPrint(resChan <-chan processedBatch, chunkCount int) {
-	aggData := make(map[string]temprature, 100000)
-	stationList := make([]string, 413)
-	stationCount := 0
+// parseSize parses a byte count with an optional K, M or G suffix (powers of
+// 1024).
+func parseSize(size string) (int, error) {
+	v, shift := size, 0
+	switch strings.ToUpper(v[len(v)-min(len(v), 1):]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		v = v[:len(v)-1]
+	}
 
-	for i := 0; i < chunkCount; i++ {
-		for _, temp := range <-resChan {
-			if temp.count == 0 {
-				continue
-			}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > math.MaxInt>>shift {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
 
-			station := unsafe.String(&temp.key[0], len(temp.key))
-			if row, ok := aggData[station]; !ok {
-				aggData[station] = temp
-				stationList[stationCount] = station
-				stationCount++
-			} else {
-				row.min = min(row.min, temp.min)
-				row.max = max(row.max, temp.max)
-				row.sum += temp.sum
-				row.count += temp.count
-				aggData[station] = row
-			}
-		}
+	return n << shift, nil
+}
+
+// run aggregates every file, glob and directory in inputs into one result,
+// and saves its snapshot to the snapshot file if set. With a checkpoint file,
+// only what was appended since the previous run is aggregated.
+func run(agg *brc.Aggregator, inputs []string, writer brc.ResultWriter, snapshot, ckpt string) error {
+	paths, err := brc.ExpandPaths(inputs...)
+	if err != nil {
+		return err
 	}
 
-	// print
-	sort.Strings(stationList)
-	for _, station := range stationList {
-		data := aggData[station]
-		if data.count == 0 {
-			continue
-		}
+	var res *brc.Result
+	if ckpt != "" {
+		res, err = runIncremental(agg, paths, ckpt)
+	} else {
+		res, err = agg.AggregateFiles(paths...)
+	}
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf(
-			"%s=%.1f/%.1f/%.1f\n",
-			station,
-			float64(data.min)/10.0,
-			(float64(data.sum)/float64(data.count))/10,
-			float64(data.max)/10.0,
-		)
+	if snapshot != "" {
+		if err := res.Snapshot().WriteFile(snapshot); err != nil {
+			return err
+		}
 	}
+
+	return report(writer, res)
 }
 
-func parseTemp(s []byte) int {
-	start := 0
-	mul := 1
-	if s[0] == '-' {
-		start = 1
-		mul = -1
+// runCoordinator aggregates every file, glob and directory in inputs with the
+// workers that connect to listen, until they are done or the process is
+// interrupted.
+func runCoordinator(coord *brc.Coordinator, listen string, inputs []string, writer brc.ResultWriter, snapshot string) error {
+	paths, err := brc.ExpandPaths(inputs...)
+	if err != nil {
+		return err
 	}
 
-	if len(s[start:]) == 3 {
-		return (((int(s[start]) - 48) * 10) + (int(s[start+2]) - 48)) * mul
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
 	}
+	fmt.Fprintln(os.Stderr, "waiting for workers on", l.Addr())
 
-	return (((int(s[start]) - 48) * 100) + ((int(s[start+1]) - 48) * 10) + (int(s[start+3]) - 48)) * mul
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func (pb *processedBatch) add(station []byte, temp int) {
-	h := hash(station)
-	bucket := &(*pb)[h]
-
-	if bucket.count == 0 {
-		*bucket = temprature{
-			min:   temp,
-			max:   temp,
-			sum:   temp,
-			count: 1,
-			key:   station,
-		}
+	res, err := coord.Aggregate(ctx, l, paths...)
+	if err != nil {
+		return err
+	}
 
-		return
+	if snapshot != "" {
+		if err := res.Snapshot().WriteFile(snapshot); err != nil {
+			return err
+		}
 	}
 
-	// found the same station
-	bucket.min = min(bucket.min, temp)
-	bucket.max = max(bucket.max, temp)
-	bucket.sum += temp
-	bucket.count++
+	return report(writer, res)
 }
 
-func hash(key []byte) uint64 {
-	hash := uint64(1)
-	for _, c := range key {
-		hash ^= uint64(c)
-		hash *= 31
+// runIncremental aggregates the bytes appended to paths since the
+// checkpoint in the file ckpt, if there is one, and updates it.
+func runIncremental(agg *brc.Aggregator, paths []string, ckpt string) (*brc.Result, error) {
+	prev, err := brc.ReadCheckpointFile(ckpt)
+	if errors.Is(err, fs.ErrNotExist) {
+		prev, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return (hash % 13696) // experimented - zero collisions
-}
+	res, next, err := agg.AggregateIncremental(prev, paths...)
+	if err != nil {
+		return nil, err
+	}
 
-func handleChunk(chunk []byte) processedBatch {
-	// s := time.Now()
-	// defer func() {
-	// 	log.Println(time.Since(s), len(chunk))
-	// }()
+	for _, f := range next.Files {
+		if f.Reset {
+			fmt.Fprintf(os.Stderr, "%s was truncated or replaced, aggregated it from the start\n", f.Path)
+		}
+	}
 
-	var (
-		start, end, splitIdx int
-		line                 []byte
+	return res, next.WriteFile(ckpt)
+}
 
-		localData = make(processedBatch, 13690)
-		chunkLen  = len(chunk)
-	)
+// report writes res to stdout and its malformed lines to stderr.
+func report(writer brc.ResultWriter, res *brc.Result) error {
+	if err := writer.WriteResult(os.Stdout, res); err != nil {
+		return err
+	}
 
-	for end < chunkLen {
-		if chunk[end] == '\n' || end == chunkLen-1 {
-			if end == chunkLen-1 && chunk[end] != '\n' {
-				end = chunkLen
-			}
+	for _, rejection := range res.Rejections {
+		fmt.Fprintln(os.Stderr, rejection)
+	}
+	if res.Rejected > len(res.Rejections) && len(res.Rejections) > 0 {
+		fmt.Fprintf(os.Stderr, "... and %d more\n", res.Rejected-len(res.Rejections))
+	}
+	if res.Rejected > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d malformed lines\n", res.Rejected)
+	}
 
-			splitIdx = end - start - 4
-			line = chunk[start:end]
-			for ; ; splitIdx-- {
-				if line[splitIdx] == ';' {
-					localData.add(line[:splitIdx], parseTemp(line[splitIdx+1:]))
-					break
-				}
-			}
+	return nil
+}
 
-			start = end + 1
-			end += 7 // smallest possible line
-			continue
-		}
+func exitCode(err error) int {
+	var (
+		fileErr  *brc.FileError
+		mmapErr  *brc.MmapError
+		parseErr *brc.ParseError
+		snapErr  *brc.SnapshotError
+	)
 
-		end++
+	switch {
+	case errors.As(err, &fileErr):
+		return exitFile
+	case errors.As(err, &mmapErr):
+		return exitMmap
+	case errors.As(err, &parseErr), errors.As(err, &snapErr):
+		return exitParse
+	default:
+		return exitUnknown
 	}
-
-	return localData
 }
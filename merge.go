@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arjunmahishi/1brcgo/brc"
+)
+
+// mergeCLI is the merge command: it combines the snapshots saved by
+// -snapshot into one result, as if their inputs had been aggregated in one
+// run.
+func mergeCLI(args []string) int {
+	var (
+		output   brc.OutputOptions
+		format   string
+		snapshot string
+	)
+
+	flags := flag.NewFlagSet("1brcgo merge", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: 1brcgo merge [flags] snapshot...")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", "text", "output format: "+strings.Join(brc.Formats(), ", "))
+	flags.BoolVar(&output.Percentiles, "percentiles", false, "also print the median, p90, p95 and p99 of every station (the snapshots need to have been taken with -percentiles)")
+	flags.BoolVar(&output.StdDev, "stddev", false, "also print the variance and standard deviation of every station")
+	flags.StringVar(&snapshot, "snapshot", "", "also save the merged state to `file`, to be merged again")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no snapshots to merge")
+		flags.Usage()
+		return exitUsage
+	}
+
+	writer, err := brc.NewResultWriter(format, output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	merged, err := mergeSnapshots(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	if output.Percentiles && !merged.Percentiles() {
+		fmt.Fprintln(os.Stderr, "-percentiles: not every snapshot was taken with -percentiles")
+		return exitUsage
+	}
+
+	if snapshot != "" {
+		if err := merged.WriteFile(snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCode(err)
+		}
+	}
+
+	res, err := merged.Result()
+	if err == nil {
+		err = report(writer, res)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	return exitOK
+}
+
+// mergeSnapshots reads the snapshot files at paths and merges them into the
+// first.
+func mergeSnapshots(paths []string) (*brc.Snapshot, error) {
+	merged, err := brc.ReadSnapshotFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths[1:] {
+		s, err := brc.ReadSnapshotFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := merged.Merge(s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arjunmahishi/1brcgo/brc"
+)
+
+// runServer serves the result of inputs over HTTP on addr until the process
+// is interrupted. inputs are either snapshots, merged like with 1brcgo
+// merge, or files, globs and directories, which are expanded and aggregated
+// again on every reload so that new files are picked up too.
+func runServer(agg *brc.Aggregator, addr string, inputs []string, output brc.OutputOptions) error {
+	load := func() (*brc.Result, error) {
+		paths, err := brc.ExpandPaths(inputs...)
+		if err != nil {
+			return nil, err
+		}
+
+		return agg.AggregateFiles(paths...)
+	}
+
+	snapshots, err := isSnapshots(inputs)
+	if err != nil {
+		return err
+	}
+	if snapshots {
+		load = func() (*brc.Result, error) {
+			merged, err := mergeSnapshots(inputs)
+			if err != nil {
+				return nil, err
+			}
+			if output.Percentiles && !merged.Percentiles() {
+				return nil, errors.New("-percentiles: not every snapshot was taken with -percentiles")
+			}
+
+			return merged.Result()
+		}
+	}
+
+	handler, err := brc.NewServer(load, output)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "serving on", l.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// isSnapshots reports whether inputs are snapshot files rather than
+// measurements, going by the first one.
+func isSnapshots(inputs []string) (bool, error) {
+	stat, err := os.Stat(inputs[0])
+	if err != nil || !stat.Mode().IsRegular() {
+		return false, nil // a glob, directory or missing file, for ExpandPaths
+	}
+
+	return brc.IsSnapshotFile(inputs[0])
+}
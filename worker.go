@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/arjunmahishi/1brcgo/brc"
+)
+
+// workerCLI is the worker command: it connects to a coordinator started
+// with -coordinate and aggregates the ranges it is handed until there are
+// none left. How lines are parsed is up to the coordinator; the flags only
+// tune how this machine reads its ranges.
+func workerCLI(args []string) int {
+	var (
+		agg     brc.Aggregator
+		backend string
+		wait    time.Duration
+	)
+
+	flags := flag.NewFlagSet("1brcgo worker", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: 1brcgo worker [flags] coordinator-address")
+		flags.PrintDefaults()
+	}
+	flags.IntVar(&agg.Workers, "workers", 0, "number of goroutines parsing the input (default the number of CPUs)")
+	flags.IntVar(&agg.SegmentSize, "segment-size", 0, "size in bytes of the segments ranges are cut into for the goroutines (default 4 MiB)")
+	flags.StringVar(&backend, "io", "mmap", "how files are read: mmap, pread or io_uring (Linux)")
+	flags.Func("memory-budget", "map at most this many bytes of the input at a time (e.g. 512M, 2G)", func(v string) error {
+		n, err := parseSize(v)
+		agg.MemoryBudget = n
+		return err
+	})
+	flags.DurationVar(&wait, "wait", 30*time.Second, "how long to keep trying to connect to a coordinator that is not up yet")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "a single coordinator address is needed")
+		flags.Usage()
+		return exitUsage
+	}
+
+	var err error
+	if agg.Backend, err = brc.ParseIOBackend(backend); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	nc, err := dial(flags.Arg(0), wait)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnknown
+	}
+	defer nc.Close()
+
+	if err := agg.Work(nc); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnknown
+	}
+
+	return exitOK
+}
+
+// dial connects to addr, retrying for up to wait.
+func dial(addr string, wait time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		nc, err := net.Dial("tcp", addr)
+		if err == nil || time.Now().After(deadline) {
+			return nc, err
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}